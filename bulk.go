@@ -0,0 +1,177 @@
+package matomo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// BulkResult is the response Matomo returns for a bulk tracking request
+type BulkResult struct {
+	// Status is either success or error
+	Status string `json:"status"`
+	// Tracked is the number of requests Matomo recorded
+	Tracked int `json:"tracked"`
+	// Invalid is the number of requests Matomo rejected
+	Invalid int `json:"invalid"`
+	// InvalidIndices are the positions of the rejected requests in the slice that was sent. Older versions of
+	// Matomo only report the Invalid count.
+	InvalidIndices []int `json:"invalid_indices"`
+	// Message is set by Matomo when the whole bulk request failed
+	Message string `json:"message"`
+}
+
+// BulkError is returned when Matomo rejected some or all of the requests in a bulk call
+type BulkError struct {
+	Result *BulkResult
+}
+
+func (e *BulkError) Error() string {
+	if e.Result.Message != "" {
+		return fmt.Sprintf("bulk request failed: %s", e.Result.Message)
+	}
+	if len(e.Result.InvalidIndices) > 0 {
+		return fmt.Sprintf("bulk request had %d invalid requests at indices %v", e.Result.Invalid, e.Result.InvalidIndices)
+	}
+	return fmt.Sprintf("bulk request had %d invalid requests", e.Result.Invalid)
+}
+
+// bulkPayload is the JSON body Matomo expects for a bulk tracking request
+type bulkPayload struct {
+	Requests  []string `json:"requests"`
+	TokenAuth string   `json:"token_auth,omitempty"`
+}
+
+// SendBulk sends all of the parameters in a single bulk request using the default client
+func SendBulk(ctx context.Context, params []*Parameters) (*BulkResult, error) {
+	return defaultClient.SendBulk(ctx, params)
+}
+
+// SendBulkToSite sends all of the parameters to the provided site in a single bulk request using the default client
+func SendBulkToSite(ctx context.Context, siteID string, params []*Parameters) (*BulkResult, error) {
+	return defaultClient.SendBulkToSite(ctx, siteID, params)
+}
+
+// SendBulk sends all of the parameters to the site id the Client was configured with in a single bulk request
+func (c *Client) SendBulk(ctx context.Context, params []*Parameters) (*BulkResult, error) {
+	if c.config.Domain == "" {
+		return nil, ErrNotConfigured
+	}
+	if c.config.SiteID == "" {
+		return nil, ErrMissingSiteID
+	}
+	return c.SendBulkToSite(ctx, c.config.SiteID, params)
+}
+
+// SendBulkToSite sends all of the parameters to the provided site in a single POST to matomo.php. The configured
+// TokenAuth is included in the payload if set. If Matomo rejects any of the requests, the returned error is a
+// *BulkError and the result lists which requests failed.
+func (c *Client) SendBulkToSite(ctx context.Context, siteID string, params []*Parameters) (*BulkResult, error) {
+	if c.config.Domain == "" {
+		return nil, ErrNotConfigured
+	}
+	if siteID == "" {
+		return nil, ErrMissingSiteID
+	}
+	// encode everything first, so an invalid request doesn't leave visits recorded for the ones before it
+	encoded := make([]map[string]string, 0, len(params))
+	for _, p := range params {
+		data, err := c.encodeRequest(siteID, p)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, data)
+	}
+	requests := make([]string, 0, len(encoded))
+	for _, data := range encoded {
+		c.trackVisit(siteID, data)
+		requests = append(requests, queryString(data))
+	}
+	return c.sendBulkRequests(ctx, requests)
+}
+
+// sendBulkRequests posts already encoded query strings to the bulk tracking endpoint, spooling them if the
+// request fails for a transient reason
+func (c *Client) sendBulkRequests(ctx context.Context, requests []string) (*BulkResult, error) {
+	result, err := c.postBulk(ctx, requests)
+	if err != nil {
+		if c.spool != nil && transient(err) {
+			return nil, c.spoolRequests(requests, err)
+		}
+		return result, err
+	}
+	if len(requests) > 0 {
+		c.replayInBackground()
+	}
+	return result, nil
+}
+
+// postBulk posts already encoded query strings to the bulk tracking endpoint
+func (c *Client) postBulk(ctx context.Context, requests []string) (result *BulkResult, err error) {
+	if c.config.Domain == "" {
+		return nil, ErrNotConfigured
+	}
+	if len(requests) == 0 {
+		return &BulkResult{Status: "success"}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+	payload := bulkPayload{
+		Requests:  requests,
+		TokenAuth: c.config.TokenAuth,
+	}
+	start := time.Now()
+	c.observer.RequestStarted(len(requests))
+	defer func() {
+		c.observe(len(requests), start, err)
+	}()
+	resp, err := c.execute(ctx, func() (*resty.Response, error) {
+		return c.http.R().
+			SetContext(ctx).
+			SetHeader("Content-Type", "application/json").
+			SetBody(payload).
+			Post(c.config.Domain + "/matomo.php")
+	})
+	if err != nil {
+		c.logger.Debug("bulk tracking request failed", "requests", len(requests), "error", err, "latency", time.Since(start))
+		return nil, err
+	}
+	c.logger.Debug("sent bulk tracking request", "requests", len(requests), "status", resp.StatusCode(), "latency", time.Since(start))
+
+	result = &BulkResult{}
+	parseErr := json.Unmarshal(resp.Body(), result)
+	statusCode := resp.StatusCode()
+	if statusCode != http.StatusOK && statusCode != http.StatusNoContent {
+		if parseErr == nil && result.Status == "error" {
+			return result, &BulkError{Result: result}
+		}
+		return nil, newStatusError(resp)
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("could not parse the bulk response: %v, body was: %+v", parseErr, string(resp.Body()))
+	}
+	if result.Status == "error" || result.Invalid > 0 {
+		return result, &BulkError{Result: result}
+	}
+	return result, nil
+}
+
+// queryString joins encoded parameters into a query string, sorted by key, escaping each value once
+func queryString(data map[string]string) string {
+	return "?" + toValues(data).Encode()
+}
+
+// toValues converts encoded parameters to url.Values, which escapes each value exactly once when it is encoded
+func toValues(data map[string]string) url.Values {
+	values := make(url.Values, len(data))
+	for k, v := range data {
+		values.Set(k, v)
+	}
+	return values
+}
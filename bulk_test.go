@@ -0,0 +1,116 @@
+package matomo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendBulk(t *testing.T) {
+	received := bulkPayload{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/matomo.php", r.URL.Path)
+		err := json.NewDecoder(r.Body).Decode(&received)
+		assert.Nil(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","tracked":2,"invalid":0}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("3"), WithTokenAuth("secret"))
+	result, err := client.SendBulk(context.Background(), []*Parameters{
+		{
+			RecommendedParameters: &RecommendedParameters{
+				ActionName: StringPtr("first"),
+			},
+		},
+		{
+			EventTrackingParameters: testEventParams,
+		},
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+	assert.Equal(t, 2, result.Tracked)
+	assert.Equal(t, "secret", received.TokenAuth)
+	assert.Equal(t, 2, len(received.Requests))
+
+	for _, request := range received.Requests {
+		assert.True(t, strings.HasPrefix(request, "?"))
+		values, err := url.ParseQuery(strings.TrimPrefix(request, "?"))
+		assert.Nil(t, err)
+		assert.Equal(t, "3", values.Get("idsite"))
+		assert.Equal(t, "1", values.Get("rec"))
+	}
+	first, _ := url.ParseQuery(strings.TrimPrefix(received.Requests[0], "?"))
+	assert.Equal(t, "first", first.Get("action_name"))
+	second, _ := url.ParseQuery(strings.TrimPrefix(received.Requests[1], "?"))
+	assert.Equal(t, *testEventParams.Category, second.Get("e_c"))
+
+	// nothing to send should not make a request
+	result, err = client.SendBulk(context.Background(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Tracked)
+}
+
+func TestSendBulkInvalidRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","tracked":1,"invalid":1,"invalid_indices":[1]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("1"))
+	result, err := client.SendBulk(context.Background(), []*Parameters{{}, {}})
+	assert.NotNil(t, err)
+	bulkErr := &BulkError{}
+	assert.True(t, errors.As(err, &bulkErr))
+	assert.Equal(t, []int{1}, bulkErr.Result.InvalidIndices)
+	assert.Equal(t, 1, result.Tracked)
+	assert.Equal(t, 1, result.Invalid)
+}
+
+func TestSendBulkFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","tracked":0,"message":"token_auth is not valid"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("1"))
+	result, err := client.SendBulk(context.Background(), []*Parameters{{}})
+	assert.NotNil(t, err)
+	assert.Equal(t, "token_auth is not valid", result.Message)
+
+	_, err = NewClient(server.URL).SendBulk(context.Background(), []*Parameters{{}})
+	assert.NotNil(t, err)
+}
+
+func TestSendBulkChecksRequestsFirst(t *testing.T) {
+	store := NewMemoryVisitorStore()
+	client := NewClient("https://matomo.example.com", WithVisitorStore(store, 0))
+	visitor := &Parameters{UserParameters: &UserParameters{UserID: StringPtr("user@example.com")}}
+
+	_, err := client.SendBulkToSite(context.Background(), "", []*Parameters{visitor})
+	assert.ErrorIs(t, err, ErrMissingSiteID)
+	_, err = NewClient("").SendBulkToSite(context.Background(), "1", []*Parameters{visitor})
+	assert.ErrorIs(t, err, ErrNotConfigured)
+
+	// a request that can't be sent fails the batch before any visit is recorded
+	_, err = client.SendBulkToSite(context.Background(), "1", []*Parameters{
+		visitor,
+		{AuthenticatedParameters: &AuthenticatedParameters{VisitorIP: StringPtr("203.0.113.7")}},
+	})
+	assert.ErrorIs(t, err, ErrTokenRequired)
+	visit, err := store.Load("1:uid:user@example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, visit)
+}
@@ -0,0 +1,114 @@
+package matomo
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Campaign is the marketing campaign a visitor arrived from, as described by the campaign parameters of the URL they
+// landed on
+type Campaign struct {
+	Name      string
+	Keyword   string
+	Source    string
+	Medium    string
+	Content   string
+	ID        string
+	Group     string
+	Placement string
+}
+
+// campaignPrefixes are the prefixes of campaign parameters, in the order Matomo prefers them
+var campaignPrefixes = []string{"mtm_", "pk_", "matomo_", "utm_"}
+
+// campaignParameters are the names, after the prefix, each campaign field may be given as
+var campaignParameters = []struct {
+	names []string
+	field func(c *Campaign) *string
+}{
+	{[]string{"campaign", "cpn"}, func(c *Campaign) *string { return &c.Name }},
+	{[]string{"keyword", "kwd", "term"}, func(c *Campaign) *string { return &c.Keyword }},
+	{[]string{"source"}, func(c *Campaign) *string { return &c.Source }},
+	{[]string{"medium"}, func(c *Campaign) *string { return &c.Medium }},
+	{[]string{"content"}, func(c *Campaign) *string { return &c.Content }},
+	{[]string{"cid", "id"}, func(c *Campaign) *string { return &c.ID }},
+	{[]string{"group"}, func(c *Campaign) *string { return &c.Group }},
+	{[]string{"placement"}, func(c *Campaign) *string { return &c.Placement }},
+}
+
+// ParseCampaign reads the campaign parameters from a landing URL, so visits that arrive through a server side
+// redirect or an email click handler are attributed to the right campaign. The mtm_, pk_, matomo_ and utm_
+// parameters are all recognized (eg: mtm_campaign, pk_kwd or utm_term), and when the same value is given with
+// several prefixes they are preferred in that order. Like the JavaScript tracker, parameters in the URL fragment
+// are used when the query string has none. It returns nil if the URL has no campaign parameters.
+func ParseCampaign(landingURL string) (*Campaign, error) {
+	parsed, err := url.Parse(landingURL)
+	if err != nil {
+		return nil, err
+	}
+	campaign := campaignFromValues(parsed.Query())
+	if campaign == nil && parsed.Fragment != "" {
+		if values, err := url.ParseQuery(parsed.Fragment); err == nil {
+			campaign = campaignFromValues(values)
+		}
+	}
+	return campaign, nil
+}
+
+// campaignFromValues fills a Campaign from the query values, returning nil if there are none
+func campaignFromValues(values url.Values) *Campaign {
+	// parameter names are not case sensitive
+	lower := map[string]string{}
+	for key, value := range values {
+		key = strings.ToLower(key)
+		if _, ok := lower[key]; !ok && len(value) > 0 && value[0] != "" {
+			lower[key] = value[0]
+		}
+	}
+	campaign := &Campaign{}
+	found := false
+	for _, parameter := range campaignParameters {
+		field := parameter.field(campaign)
+	prefixes:
+		for _, prefix := range campaignPrefixes {
+			for _, name := range parameter.names {
+				if value, ok := lower[prefix+name]; ok {
+					*field = value
+					found = true
+					break prefixes
+				}
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	return campaign
+}
+
+// Apply sets the campaign parameters on params, without replacing values that are already set. The source,
+// medium, content, id, group and placement are only recorded if the MarketingCampaignsReporting plugin is
+// installed.
+func (c *Campaign) Apply(params *Parameters) {
+	if params.UserParameters == nil {
+		params.UserParameters = &UserParameters{}
+	}
+	user := params.UserParameters
+	for _, field := range []struct {
+		target **string
+		value  string
+	}{
+		{&user.CampaignName, c.Name},
+		{&user.CampaignKeyword, c.Keyword},
+		{&user.CampaignSource, c.Source},
+		{&user.CampaignMedium, c.Medium},
+		{&user.CampaignContent, c.Content},
+		{&user.CampaignID, c.ID},
+		{&user.CampaignGroup, c.Group},
+		{&user.CampaignPlacement, c.Placement},
+	} {
+		if *field.target == nil && field.value != "" {
+			*field.target = StringPtr(field.value)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package matomo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCampaign(t *testing.T) {
+	campaign, err := ParseCampaign("https://example.com/landing?mtm_campaign=spring&mtm_kwd=shoes&mtm_source=newsletter" +
+		"&mtm_medium=email&mtm_content=header&mtm_cid=42&mtm_group=seasonal&mtm_placement=top")
+	assert.Nil(t, err)
+	assert.Equal(t, &Campaign{
+		Name:      "spring",
+		Keyword:   "shoes",
+		Source:    "newsletter",
+		Medium:    "email",
+		Content:   "header",
+		ID:        "42",
+		Group:     "seasonal",
+		Placement: "top",
+	}, campaign)
+
+	// mtm_ is preferred over pk_, matomo_ and utm_, and names are not case sensitive
+	campaign, err = ParseCampaign("/landing?utm_campaign=google&PK_CAMPAIGN=piwik&utm_term=boots&utm_medium=cpc&matomo_source=ads")
+	assert.Nil(t, err)
+	assert.Equal(t, "piwik", campaign.Name)
+	assert.Equal(t, "boots", campaign.Keyword)
+	assert.Equal(t, "cpc", campaign.Medium)
+	assert.Equal(t, "ads", campaign.Source)
+
+	// the fragment is used when the query string has no campaign
+	campaign, err = ParseCampaign("/landing?page=2#pk_campaign=fragment")
+	assert.Nil(t, err)
+	assert.Equal(t, "fragment", campaign.Name)
+
+	campaign, err = ParseCampaign("/landing?page=2")
+	assert.Nil(t, err)
+	assert.Nil(t, campaign)
+
+	_, err = ParseCampaign("%zz")
+	assert.NotNil(t, err)
+}
+
+func TestCampaignApply(t *testing.T) {
+	campaign := &Campaign{Name: "spring", Keyword: "shoes", Medium: "email"}
+	params := &Parameters{UserParameters: &UserParameters{CampaignKeyword: StringPtr("kept")}}
+	campaign.Apply(params)
+
+	encoded := mustEncode(t, params.UserParameters)
+	assert.Equal(t, "spring", encoded["_rcn"])
+	assert.Equal(t, "kept", encoded["_rck"])
+	assert.Equal(t, "email", encoded["_rcm"])
+	_, ok := encoded["_rcs"]
+	assert.False(t, ok)
+}
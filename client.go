@@ -1,41 +1,291 @@
 package matomo
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 )
 
+// ErrTokenRequired is returned when a request contains parameters Matomo only accepts with a token_auth, such as
+// an overridden visitor IP, but the Client has no TokenAuth configured
+var ErrTokenRequired = errors.New("the request overrides values that require a token_auth, but none was configured")
+
+// Client sends tracking requests to a single Matomo installation. Each Client owns its own Configuration and HTTP
+// client, so several Clients can be used side by side to talk to different Matomo servers from the same process.
+// The package-level Send and SendToSite functions use a default Client built from the environment in Setup.
+type Client struct {
+	config      *Configuration
+	http        *resty.Client
+	httpOptions httpOptions
+	logger      Logger
+	observer    Observer
+	retry       *RetryPolicy
+	spool       *Spool
+	strict      bool
+
+	// post sends single requests as a form body, for those whose URL would be longer than maxURLLength if set
+	post         bool
+	maxURLLength int
+
+	// visitors tracks visit counts and timestamps, with visitorsMu serializing its use
+	visitors     VisitorStore
+	visitTimeout time.Duration
+	visitorsMu   sync.Mutex
+}
+
+// Option configures a Client when it is created with NewClient
+type Option func(*Client)
+
+// WithSiteID sets the site id that Send will use when one is not provided in the call
+func WithSiteID(siteID string) Option {
+	return func(c *Client) {
+		c.config.SiteID = siteID
+	}
+}
+
+// WithTokenAuth sets the token_auth sent with requests that require authentication
+func WithTokenAuth(token string) Option {
+	return func(c *Client) {
+		c.config.TokenAuth = token
+	}
+}
+
+// DefaultMaxURLLength is a URL length that proxies and web servers generally accept, for use with WithPostBody
+const DefaultMaxURLLength = 2048
+
+// WithPostBody makes the Client send single tracking requests as a POST with an application/x-www-form-urlencoded
+// body instead of in the query string, so long values such as ec_items, _cvar or long URLs aren't cut off by URL
+// length limits, and the token_auth stays out of access logs. If maxURLLength is greater than zero, only requests
+// whose GET URL would be longer than it are sent as a POST, so the rest are unchanged. Bulk requests are always sent
+// as a POST.
+func WithPostBody(maxURLLength int) Option {
+	return func(c *Client) {
+		c.post = true
+		c.maxURLLength = maxURLLength
+	}
+}
+
+// NewClient creates a Client for the Matomo installation at domain. The domain should include the protocol and, if
+// needed, the port (eg: https://matomo.mydomain.com). A trailing /matomo.php will be removed for you.
+func NewClient(domain string, opts ...Option) *Client {
+	c := &Client{
+		config: &Configuration{
+			Domain: normalizeDomain(domain),
+			Rec:    "1",
+		},
+		logger:   NewStdLogger(nil, false),
+		observer: NopObserver{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.http = c.httpOptions.build()
+	// the spool is inherited after every option has run, so WithLogger and WithObserver may come after WithSpool
+	if c.spool != nil {
+		c.spool.inherit(c.logger, c.observer)
+	}
+	return c
+}
+
+func newClient(config *Configuration) *Client {
+	return &Client{
+		config:   config,
+		http:     (&httpOptions{}).build(),
+		logger:   NewStdLogger(nil, false),
+		observer: NopObserver{},
+	}
+}
+
+// Configuration returns a copy of the configuration the Client is using
+func (c *Client) Configuration() Configuration {
+	return *c.config
+}
+
 // Send is a helper function that reads the siteID from the configuration file rather than requiring the user
 // to provide it.
 func Send(params *Parameters) error {
-	if config.Domain == "" || config.SiteID == "" {
-		return errors.New("either domain or site id are not provided")
-	}
-	return SendToSite(config.SiteID, params)
+	return defaultClient.Send(params)
 }
 
-// SendToSite sends the parameters to Matomo instance. Matomo wants all of the data in the query string, regardless of whether
-// GET or POST is used.
+// SendContext is like Send but honours the cancellation and deadline of ctx
+func SendContext(ctx context.Context, params *Parameters) error {
+	return defaultClient.SendContext(ctx, params)
+}
+
+// SendToSite sends the parameters to Matomo instance using the default client.
 func SendToSite(siteID string, params *Parameters) error {
-	if config.Domain == "" {
-		return errors.New("the domain was not provided")
+	return defaultClient.SendToSite(siteID, params)
+}
+
+// SendToSiteContext is like SendToSite but honours the cancellation and deadline of ctx
+func SendToSiteContext(ctx context.Context, siteID string, params *Parameters) error {
+	return defaultClient.SendToSiteContext(ctx, siteID, params)
+}
+
+// Send sends the parameters to the site id the Client was configured with
+func (c *Client) Send(params *Parameters) error {
+	return c.SendContext(context.Background(), params)
+}
+
+// SendContext sends the parameters to the site id the Client was configured with. If ctx is cancelled or its
+// deadline passes before Matomo responds, the returned error wraps ctx.Err() so it can be checked with errors.Is.
+func (c *Client) SendContext(ctx context.Context, params *Parameters) error {
+	if c.config.Domain == "" {
+		return ErrNotConfigured
 	}
-	data := params.encode()
-	// set the required parameters
-	data["idsite"] = siteID
-	data["rec"] = config.Rec
+	if c.config.SiteID == "" {
+		return ErrMissingSiteID
+	}
+	return c.SendToSiteContext(ctx, c.config.SiteID, params)
+}
+
+// SendToSite sends the parameters to Matomo instance. They are sent in the query string of a GET request, unless the
+// Client was created with WithPostBody.
+func (c *Client) SendToSite(siteID string, params *Parameters) error {
+	return c.SendToSiteContext(context.Background(), siteID, params)
+}
+
+// SendToSiteContext sends the parameters to the Matomo instance for the provided site. If ctx is cancelled or its
+// deadline passes before Matomo responds, the returned error wraps ctx.Err() so it can be checked with errors.Is.
+func (c *Client) SendToSiteContext(ctx context.Context, siteID string, params *Parameters) error {
+	if c.config.Domain == "" {
+		return ErrNotConfigured
+	}
+	if siteID == "" {
+		return ErrMissingSiteID
+	}
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+	data, err := c.buildRequest(siteID, params)
+	if err != nil {
+		return err
+	}
+
+	err = c.sendSingle(ctx, data)
+	if err != nil {
+		if c.spool != nil && transient(err) {
+			return c.spoolRequests([]string{queryString(data)}, err)
+		}
+		return err
+	}
+	c.replayInBackground()
+	return nil
+}
 
-	client := resty.New()
-	resp, err := client.R().SetQueryParams(data).Get(config.Domain + "/matomo.php")
+// sendSingle sends a single tracking request, adding the token_auth if the request needs it
+func (c *Client) sendSingle(ctx context.Context, data map[string]string) error {
+	query := data
+	if requiresToken(data) {
+		query = make(map[string]string, len(data)+1)
+		for k, v := range data {
+			query[k] = v
+		}
+		query["token_auth"] = c.config.TokenAuth
+	}
+	values := toValues(query)
+	endpoint := c.config.Domain + "/matomo.php"
+	post := c.post && (c.maxURLLength <= 0 || len(endpoint)+1+len(values.Encode()) > c.maxURLLength)
+	method := http.MethodGet
+	if post {
+		method = http.MethodPost
+	}
+	start := time.Now()
+	c.observer.RequestStarted(1)
+	resp, err := c.execute(ctx, func() (*resty.Response, error) {
+		if post {
+			return c.http.R().SetContext(ctx).SetFormDataFromValues(values).Post(endpoint)
+		}
+		return c.http.R().SetContext(ctx).SetQueryParamsFromValues(values).Get(endpoint)
+	})
+	// the token is left out of the logged url
+	logURL := endpoint + queryString(data)
 	if err != nil {
+		c.logger.Debug("tracking request failed", "method", method, "url", logURL, "error", err, "latency", time.Since(start))
+		c.observe(1, start, err)
 		return err
 	}
+	c.logger.Debug("sent tracking request", "method", method, "url", logURL, "status", resp.StatusCode(), "latency", time.Since(start))
 	statusCode := resp.StatusCode()
 	if statusCode != http.StatusOK && statusCode != http.StatusNoContent {
-		return fmt.Errorf("invalid status code returned: %d, body was: %+v", statusCode, string(resp.Body()))
+		err = newStatusError(resp)
 	}
-	return nil
+	c.observe(1, start, err)
+	return err
+}
+
+// buildRequest encodes the parameters and adds the values Matomo requires on every tracking request, along with the
+// visit details from the visitor store. The token_auth is not included, so the result is safe to queue or write to
+// disk.
+func (c *Client) buildRequest(siteID string, params *Parameters) (map[string]string, error) {
+	data, err := c.encodeRequest(siteID, params)
+	if err != nil {
+		return nil, err
+	}
+	c.trackVisit(siteID, data)
+	return data, nil
+}
+
+// encodeRequest is buildRequest without the visit details, so a batch can be checked before any visit is recorded
+func (c *Client) encodeRequest(siteID string, params *Parameters) (map[string]string, error) {
+	if c.strict {
+		if err := c.validateRequest(params); err != nil {
+			return nil, err
+		}
+	}
+	data, err := Encode(params)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.TokenAuth == "" && requiresToken(data) {
+		return nil, ErrTokenRequired
+	}
+	// set the required parameters
+	data["idsite"] = siteID
+	data["rec"] = c.config.Rec
+	// a retried or spooled request must be recorded at the time of the first attempt
+	if _, ok := data["cdt"]; !ok && (c.retry.enabled() || c.spool != nil) {
+		data["cdt"] = fmt.Sprintf("%d", time.Now().Unix())
+	}
+	return data, nil
+}
+
+// authenticatedKeys are the parameters Matomo ignores unless the request has a valid token_auth
+var authenticatedKeys = []string{"cip", "country", "region", "city", "lat", "long"}
+
+// requiresToken returns true if the encoded request contains parameters Matomo only accepts with a token_auth. A
+// request time only needs one when it is more than 24 hours in the past.
+func requiresToken(data map[string]string) bool {
+	for _, key := range authenticatedKeys {
+		if _, ok := data[key]; ok {
+			return true
+		}
+	}
+	at, ok := requestTime(data)
+	if !ok {
+		// let Matomo decide what to do with a value it may not understand
+		return false
+	}
+	return time.Since(at) > 24*time.Hour
+}
+
+// requestTime returns the cdt of the encoded request, or false if it isn't set or can't be parsed
+func requestTime(data map[string]string) (time.Time, bool) {
+	cdt, ok := data["cdt"]
+	if !ok {
+		return time.Time{}, false
+	}
+	if unix, err := strconv.ParseInt(cdt, 10, 64); err == nil {
+		return time.Unix(unix, 0), true
+	}
+	if parsed, err := time.Parse("2006-01-02 15:04:05", cdt); err == nil {
+		return parsed, true
+	}
+	return time.Time{}, false
 }
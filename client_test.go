@@ -1,8 +1,16 @@
 package matomo
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,3 +30,223 @@ func TestClient(t *testing.T) {
 	err := Send(&testAllParams)
 	assert.Nil(t, err)
 }
+
+func TestClientSendToSite(t *testing.T) {
+	received := url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/matomo.php", r.URL.Path)
+		received = r.URL.Query()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL+"/matomo.php", WithSiteID("7"))
+	assert.Equal(t, server.URL, client.Configuration().Domain)
+	assert.Equal(t, "7", client.Configuration().SiteID)
+
+	err := client.Send(&Parameters{
+		RecommendedParameters: &RecommendedParameters{
+			ActionName: StringPtr("client_test"),
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "7", received.Get("idsite"))
+	assert.Equal(t, "1", received.Get("rec"))
+	assert.Equal(t, "client_test", received.Get("action_name"))
+
+	// a second client should not be affected by the first
+	other := NewClient(server.URL)
+	err = other.Send(&Parameters{})
+	assert.NotNil(t, err)
+	err = other.SendToSite("8", &Parameters{})
+	assert.Nil(t, err)
+	assert.Equal(t, "8", received.Get("idsite"))
+}
+
+func TestClientBadStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("1"))
+	err := client.Send(&Parameters{})
+	assert.NotNil(t, err)
+
+	err = NewClient("").SendToSite("1", &Parameters{})
+	assert.NotNil(t, err)
+}
+
+func TestClientSendContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewClient(server.URL, WithSiteID("1"))
+
+	// a deadline that passes while waiting on the server
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.SendContext(ctx, &Parameters{})
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// an already cancelled context should never reach the server
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = client.SendToSiteContext(ctx, "1", &Parameters{})
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestClientTokenAuth(t *testing.T) {
+	received := url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.URL.Query()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	overrides := &Parameters{
+		AuthenticatedParameters: &AuthenticatedParameters{
+			VisitorIP: StringPtr("203.0.113.7"),
+		},
+	}
+
+	// overrides are refused without a token
+	client := NewClient(server.URL, WithSiteID("1"))
+	err := client.Send(overrides)
+	assert.Equal(t, ErrTokenRequired, err)
+	_, err = client.SendBulk(context.Background(), []*Parameters{overrides})
+	assert.Equal(t, ErrTokenRequired, err)
+
+	// a recent request time does not need a token, but an old one does
+	err = client.Send(&Parameters{
+		AuthenticatedParameters: &AuthenticatedParameters{
+			RequestTime: TimePtr(time.Now().Add(-time.Hour)),
+		},
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, received.Get("token_auth"))
+	err = client.Send(&Parameters{
+		AuthenticatedParameters: &AuthenticatedParameters{
+			RequestTime: TimePtr(time.Now().Add(-48 * time.Hour)),
+		},
+	})
+	assert.Equal(t, ErrTokenRequired, err)
+
+	// with a token, the overrides are sent along with it
+	client = NewClient(server.URL, WithSiteID("1"), WithTokenAuth("secret"))
+	err = client.Send(overrides)
+	assert.Nil(t, err)
+	assert.Equal(t, "203.0.113.7", received.Get("cip"))
+	assert.Equal(t, "secret", received.Get("token_auth"))
+
+	// the token is only sent when it is needed
+	err = client.Send(&Parameters{})
+	assert.Nil(t, err)
+	assert.Empty(t, received.Get("token_auth"))
+}
+
+func TestSingleEscaping(t *testing.T) {
+	received := make(chan url.Values, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			payload := bulkPayload{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			for _, request := range payload.Requests {
+				values, _ := url.ParseQuery(strings.TrimPrefix(request, "?"))
+				received <- values
+			}
+			w.Write([]byte(`{"status":"success","tracked":1,"invalid":0}`))
+			return
+		}
+		received <- r.URL.Query()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("1"), WithTokenAuth("tok&en=1"))
+	params := &Parameters{
+		UserParameters: &UserParameters{CampaignKeyword: StringPtr("Keyword Test")},
+		EventTrackingParameters: &EventTrackingParameters{
+			Category: StringPtr("100% café"),
+			Action:   StringPtr("a=b&c+d"),
+		},
+		AuthenticatedParameters: &AuthenticatedParameters{VisitorIP: StringPtr("2001:db8::1")},
+	}
+	assert.Nil(t, client.Send(params))
+	_, err := client.SendBulk(context.Background(), []*Parameters{params})
+	assert.Nil(t, err)
+
+	// both the GET and the bulk request must decode to the original values
+	for _, name := range []string{"get", "bulk"} {
+		values := <-received
+		assert.Equal(t, "Keyword Test", values.Get("_rck"), name)
+		assert.Equal(t, "100% café", values.Get("e_c"), name)
+		assert.Equal(t, "a=b&c+d", values.Get("e_a"), name)
+		assert.Equal(t, "2001:db8::1", values.Get("cip"), name)
+		if name == "get" {
+			assert.Equal(t, "tok&en=1", values.Get("token_auth"))
+		}
+	}
+}
+
+func TestPostBody(t *testing.T) {
+	type request struct {
+		method      string
+		contentType string
+		query       url.Values
+		form        url.Values
+	}
+	received := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		r.ParseForm()
+		received <- request{method: r.Method, contentType: r.Header.Get("Content-Type"), query: query, form: r.PostForm}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	short := &Parameters{RecommendedParameters: &RecommendedParameters{ActionName: StringPtr("Home")}}
+	long := &Parameters{RecommendedParameters: &RecommendedParameters{ActionName: StringPtr(strings.Repeat("a", 3000))}}
+
+	// GET is the default, however long the URL
+	client := NewClient(server.URL, WithSiteID("1"))
+	assert.Nil(t, client.Send(long))
+	req := <-received
+	assert.Equal(t, http.MethodGet, req.method)
+	assert.Equal(t, 3000, len(req.query.Get("action_name")))
+
+	// every request is a POST without a threshold, including the token_auth
+	client = NewClient(server.URL, WithSiteID("1"), WithTokenAuth("secret"), WithPostBody(0))
+	assert.Nil(t, client.Send(&Parameters{
+		RecommendedParameters:   short.RecommendedParameters,
+		AuthenticatedParameters: &AuthenticatedParameters{VisitorIP: StringPtr("203.0.113.7")},
+	}))
+	req = <-received
+	assert.Equal(t, http.MethodPost, req.method)
+	assert.Equal(t, "application/x-www-form-urlencoded", req.contentType)
+	assert.Empty(t, req.query)
+	assert.Equal(t, "Home", req.form.Get("action_name"))
+	assert.Equal(t, "1", req.form.Get("idsite"))
+	assert.Equal(t, "secret", req.form.Get("token_auth"))
+
+	// with a threshold, only long requests fall back to a POST
+	client = NewClient(server.URL, WithSiteID("1"), WithPostBody(DefaultMaxURLLength))
+	assert.Nil(t, client.Send(short))
+	req = <-received
+	assert.Equal(t, http.MethodGet, req.method)
+	assert.Equal(t, "Home", req.query.Get("action_name"))
+	assert.Nil(t, client.Send(long))
+	req = <-received
+	assert.Equal(t, http.MethodPost, req.method)
+	assert.Equal(t, 3000, len(req.form.Get("action_name")))
+}
@@ -1,36 +1,49 @@
 package matomo
 
 import (
-	"fmt"
 	"os"
 	"strings"
 )
 
+// Configuration holds the settings a Client uses to reach a Matomo installation
 type Configuration struct {
 	Domain string
 	SiteID string // if not provided, will be required in the call
 	Rec    string // currently must always be set to 1
+	// TokenAuth is the token_auth of a Matomo user with write access to the site. It is only needed for requests
+	// that Matomo requires to be authenticated, such as bulk requests that override the visitor IP or timestamp.
+	TokenAuth string
 }
 
 var config *Configuration
 
+// defaultClient is used by the package-level send functions and shares the package configuration
+var defaultClient *Client
+
 func Setup() {
 	if config != nil {
 		return
 	}
 	config = &Configuration{}
 	config.Domain = strings.TrimSuffix(envHelper("MATOMO_DOMAIN", ""), "/")
-	if config.Domain == "" {
-		// TODO: convert to logger
-		fmt.Printf("\n----------------------------\nERROR: MATOMO_DOMAIN was not set, so events will not be tracked\n----------------------------\n")
-		fmt.Fprintf(os.Stderr, "ERROR: MATOMO_DOMAIN was not set, so events will not be tracked\n")
-	}
 	// make sure they didn't put the matomo.php at the end
-	config.Domain = strings.TrimSuffix(config.Domain, "matomo.php")
+	config.Domain = normalizeDomain(config.Domain)
 	config.SiteID = envHelper("MATOMO_SITE_ID", "")
+	config.TokenAuth = envHelper("MATOMO_TOKEN_AUTH", "")
 
 	config.Rec = "1"
 
+	defaultClient = newClient(config)
+	if config.Domain == "" {
+		defaultClient.logger.Warn("MATOMO_DOMAIN was not set, so events will not be tracked")
+	}
+}
+
+// normalizeDomain strips any trailing slash and /matomo.php from the domain so it can have paths appended
+func normalizeDomain(domain string) string {
+	domain = strings.TrimSuffix(domain, "/")
+	domain = strings.TrimSuffix(domain, "matomo.php")
+	return strings.TrimSuffix(domain, "/")
 }
 
 func envHelper(key, defaultValue string) string {
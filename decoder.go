@@ -0,0 +1,271 @@
+package matomo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unmarshaler is implemented by types that decode themselves from a single Matomo parameter value. It is the
+// inverse of Marshaler.
+type Unmarshaler interface {
+	UnmarshalMatomo(value string) error
+}
+
+// decodeChecker is implemented by parameter structs that decide whether the decoded values describe them, for
+// values that are shared with another struct or that their encoding would drop. Values of a struct that is not
+// kept end up in Extra, so they are still sent.
+type decodeChecker interface {
+	decoded(values url.Values) bool
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// ParseParameters decodes a Matomo tracking query string, such as one sent by the JavaScript tracker, into
+// Parameters. It is the inverse of the encoding used when sending: every known parameter is decoded into its typed
+// field, and anything else (including idsite and rec) is kept in Extra, so encoding the result sends the same
+// parameters. Encoding also fills in the defaults the SDK sends when they are missing: apiv and rand, and the
+// visitor's local time in h, m and s if there are any UserParameters. Only the first value of each parameter is
+// used.
+func ParseParameters(values url.Values) (*Parameters, error) {
+	params := &Parameters{}
+	used := map[string]bool{}
+	if _, err := decodeValue(reflect.ValueOf(params).Elem(), values, used); err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		if used[key] || len(value) == 0 {
+			continue
+		}
+		if params.Extra == nil {
+			params.Extra = map[string]string{}
+		}
+		params.Extra[key] = value[0]
+	}
+	return params, nil
+}
+
+// decodeValue fills the struct v from values, marking each parameter it used. It returns true if any field was set.
+func decodeValue(v reflect.Value, values url.Values, used map[string]bool) (bool, error) {
+	set := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, options := parseTag(field.Tag.Get("matomo"))
+		if name == "-" || options.has("extra") {
+			continue
+		}
+		fv := v.Field(i)
+		if options.has("inline") || (name == "" && isNestedStruct(field.Type)) {
+			if !isNestedStruct(field.Type) {
+				// slices of extensions can't be decoded since their types are unknown
+				continue
+			}
+			ok, err := decodeNested(fv, values, used)
+			if err != nil {
+				return set, fmt.Errorf("%s: %v", field.Name, err)
+			}
+			set = set || ok
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		ok, err := decodeField(name, fv, values, used)
+		if err != nil {
+			return set, err
+		}
+		set = set || ok
+	}
+	return set, nil
+}
+
+// decodeNested decodes a nested struct, only keeping it if something was set
+func decodeNested(v reflect.Value, values url.Values, used map[string]bool) (bool, error) {
+	if v.Kind() != reflect.Ptr {
+		return decodeValue(v, values, used)
+	}
+	// the parameters are only marked as used once the struct is kept, so a rejected one leaves them in Extra
+	nested := reflect.New(v.Type().Elem())
+	nestedUsed := map[string]bool{}
+	ok, err := decodeValue(nested.Elem(), values, nestedUsed)
+	if err != nil || !ok {
+		return false, err
+	}
+	if checker, isChecker := nested.Interface().(decodeChecker); isChecker && !checker.decoded(values) {
+		return false, nil
+	}
+	for name := range nestedUsed {
+		used[name] = true
+	}
+	v.Set(nested)
+	return true, nil
+}
+
+// decodeField decodes the parameter name into a single tagged field, returning true if it was present
+func decodeField(name string, v reflect.Value, values url.Values, used map[string]bool) (bool, error) {
+	if v.Kind() == reflect.Map {
+		return decodeMap(name, v, values, used)
+	}
+	raw, ok := values[name]
+	if !ok || len(raw) == 0 {
+		return false, nil
+	}
+	used[name] = true
+	target := v
+	if v.Kind() == reflect.Ptr {
+		target = reflect.New(v.Type().Elem()).Elem()
+	}
+	if err := parseValue(target, raw[0]); err != nil {
+		return false, fmt.Errorf("invalid value for %s: %v", name, err)
+	}
+	if v.Kind() == reflect.Ptr {
+		v.Set(target.Addr())
+	}
+	return true, nil
+}
+
+// decodeMap decodes every parameter named name followed by a number, such as dimension1, into a map
+func decodeMap(name string, v reflect.Value, values url.Values, used map[string]bool) (bool, error) {
+	set := false
+	for key, raw := range values {
+		if !strings.HasPrefix(key, name) || len(raw) == 0 {
+			continue
+		}
+		id := strings.TrimPrefix(key, name)
+		if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+			continue
+		}
+		mapKey := reflect.New(v.Type().Key()).Elem()
+		if err := parseValue(mapKey, id); err != nil {
+			return set, fmt.Errorf("invalid key for %s: %v", key, err)
+		}
+		mapValue := reflect.New(v.Type().Elem()).Elem()
+		if err := parseValue(mapValue, raw[0]); err != nil {
+			return set, fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		v.SetMapIndex(mapKey, mapValue)
+		used[key] = true
+		set = true
+	}
+	return set, nil
+}
+
+// parseValue sets v from the string Matomo received. It is the inverse of formatValue.
+func parseValue(v reflect.Value, raw string) error {
+	if reflect.PtrTo(v.Type()).Implements(unmarshalerType) {
+		return v.Addr().Interface().(Unmarshaler).UnmarshalMatomo(raw)
+	}
+	switch v.Type() {
+	case timeType:
+		if unix, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			v.Set(reflect.ValueOf(time.Unix(unix, 0)))
+			return nil
+		}
+		parsed, err := time.Parse("2006-01-02 15:04:05", raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(parsed))
+		return nil
+	case durationType:
+		ms, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(time.Duration(ms) * time.Millisecond))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(parsed)
+	default:
+		return fmt.Errorf("cannot decode a value of type %s", v.Type())
+	}
+	return nil
+}
+
+// UnmarshalMatomo parses the JSON array of arrays Matomo uses for ecommerce items
+func (items *EcommerceItems) UnmarshalMatomo(value string) error {
+	rows := [][]interface{}{}
+	if err := json.Unmarshal([]byte(value), &rows); err != nil {
+		return err
+	}
+	parsed := EcommerceItems{}
+	for _, row := range rows {
+		item := EcommerceItem{}
+		for i, column := range row {
+			switch i {
+			case 0:
+				item.SKU = jsonString(column)
+			case 1:
+				item.Name = jsonString(column)
+			case 2:
+				item.Category = jsonString(column)
+			case 3:
+				item.Price = jsonNumber(column)
+			case 4:
+				item.Quantity = int64(jsonNumber(column))
+			}
+		}
+		parsed = append(parsed, item)
+	}
+	*items = parsed
+	return nil
+}
+
+// jsonString reads a string from a decoded JSON value, re-encoding anything that isn't one (such as the list of
+// categories the JavaScript tracker allows)
+func jsonString(value interface{}) string {
+	if v, ok := value.(string); ok {
+		return v
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// jsonNumber reads a number from a decoded JSON value that may have been sent as a string
+func jsonNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		parsed, _ := strconv.ParseFloat(v, 64)
+		return parsed
+	}
+	return 0
+}
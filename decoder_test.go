@@ -0,0 +1,124 @@
+package matomo
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseParametersRoundTrip(t *testing.T) {
+	requestTime := time.Date(2021, 6, 9, 12, 0, 0, 0, time.UTC)
+	original := Parameters{
+		RecommendedParameters: &RecommendedParameters{
+			ActionName: StringPtr("Help / Feedback"),
+			URL:        StringPtr("https://example.com/help?topic=feedback"),
+			VisitorID:  StringPtr("0123456789abcdef"),
+		},
+		UserParameters:            testUserParams,
+		ActionParameters:          testActionParams,
+		PagePerformanceParameters: NewServerTiming(150 * time.Millisecond),
+		EventTrackingParameters:   testEventParams,
+		ContentTrackingParameters: NewContentInteraction("click", "Spring Sale", "/banner.png", "/sale"),
+		EcommerceParameters:       testEcommerceParams,
+		AuthenticatedParameters: &AuthenticatedParameters{
+			VisitorIP:   StringPtr("203.0.113.7"),
+			RequestTime: TimePtr(requestTime),
+			Latitude:    Float64Ptr(40.7128),
+		},
+		Extra: map[string]string{
+			"idsite":  "1",
+			"plugin":  "custom value",
+			"unknown": "kept",
+		},
+	}
+	encoded := original.encode()
+	values, err := url.ParseQuery(strings.TrimPrefix(queryString(encoded), "?"))
+	assert.Nil(t, err)
+
+	parsed, err := ParseParameters(values)
+	assert.Nil(t, err)
+	assert.Equal(t, encoded, parsed.encode())
+
+	assert.Equal(t, "Help / Feedback", *parsed.RecommendedParameters.ActionName)
+	assert.Equal(t, *original.RecommendedParameters.Rand, *parsed.RecommendedParameters.Rand)
+	assert.Equal(t, "ServerTest", *parsed.UserParameters.UserAgent)
+	assert.True(t, *parsed.UserParameters.UserPlugins.Flash)
+	assert.False(t, *parsed.UserParameters.UserPlugins.RealPlayer)
+	assert.Equal(t, "EU West", parsed.ActionParameters.Dimensions[12])
+	assert.Equal(t, int64(150), *parsed.PagePerformanceParameters.ServerTime)
+	assert.Equal(t, 42.42, *parsed.EventTrackingParameters.Value)
+	assert.Equal(t, "click", *parsed.ContentTrackingParameters.Interaction)
+	assert.Equal(t, testEcommerceParams.Items, parsed.EcommerceParameters.Items)
+	assert.True(t, requestTime.Equal(*parsed.AuthenticatedParameters.RequestTime))
+	assert.Equal(t, map[string]string{"idsite": "1", "plugin": "custom value", "unknown": "kept"}, parsed.Extra)
+}
+
+func TestParseParametersFromTracker(t *testing.T) {
+	// a request as sent by the JavaScript tracker
+	values, err := url.ParseQuery("idsite=1&rec=1&r=123456&h=10&m=5&s=42&url=https%3A%2F%2Fexample.com%2F&_id=0123456789abcdef&res=1920x1080&cookie=1&pdf=1&idgoal=2&revenue=9.99&dimension3=blue&send_image=0")
+	assert.Nil(t, err)
+	parsed, err := ParseParameters(values)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com/", *parsed.RecommendedParameters.URL)
+	assert.Equal(t, "10", *parsed.UserParameters.CurrentHour)
+	assert.True(t, *parsed.UserParameters.UserPlugins.PDF)
+	assert.Equal(t, int64(2), *parsed.ActionParameters.GoalID)
+	assert.Equal(t, 9.99, *parsed.ActionParameters.Revenue)
+	assert.Equal(t, "blue", parsed.ActionParameters.Dimensions[3])
+	// revenue for a goal is not an ecommerce interaction
+	assert.Nil(t, parsed.EcommerceParameters)
+	assert.Nil(t, parsed.EventTrackingParameters)
+	assert.Equal(t, "1", parsed.Extra["idsite"])
+	assert.Equal(t, "123456", parsed.Extra["r"])
+	assert.Equal(t, "0", parsed.Extra["send_image"])
+
+	// ecommerce fields outside an ecommerce interaction are kept in Extra rather than lost
+	values, err = url.ParseQuery("idgoal=2&revenue=9.99&ec_id=A100&ec_st=8.99")
+	assert.Nil(t, err)
+	parsed, err = ParseParameters(values)
+	assert.Nil(t, err)
+	assert.Nil(t, parsed.EcommerceParameters)
+	assert.Equal(t, 9.99, *parsed.ActionParameters.Revenue)
+	assert.Equal(t, map[string]string{"ec_id": "A100", "ec_st": "8.99"}, parsed.Extra)
+
+	// as are event and content fields missing the ones they require
+	for _, query := range []string{"e_c=Foo&idsite=1", "e_a=play&e_n=clip&e_v=2", "c_p=%2Fbanner.png&c_t=%2Fsale"} {
+		values, err = url.ParseQuery(query)
+		assert.Nil(t, err)
+		parsed, err = ParseParameters(values)
+		assert.Nil(t, err)
+		assert.Nil(t, parsed.EventTrackingParameters, query)
+		assert.Nil(t, parsed.ContentTrackingParameters, query)
+		assert.Equal(t, toValues(parsed.encode()), values, query)
+	}
+
+	// invalid values are reported
+	_, err = ParseParameters(url.Values{"_idvc": []string{"many"}})
+	assert.NotNil(t, err)
+	_, err = ParseParameters(url.Values{"ec_items": []string{"not json"}})
+	assert.NotNil(t, err)
+}
+
+func TestParseParametersEncodeDefaults(t *testing.T) {
+	parsed, err := ParseParameters(url.Values{"action_name": []string{"Home"}})
+	assert.Nil(t, err)
+	assert.Nil(t, parsed.RecommendedParameters.Rand)
+
+	// encoding adds the parameters the SDK always sends
+	encoded := parsed.encode()
+	assert.Equal(t, "1", encoded["apiv"])
+	assert.NotEmpty(t, encoded["rand"])
+	assert.Len(t, encoded, 3)
+
+	// and the local time once there are user parameters
+	parsed, err = ParseParameters(url.Values{"action_name": []string{"Home"}, "res": []string{"1920x1080"}})
+	assert.Nil(t, err)
+	encoded = parsed.encode()
+	for _, name := range []string{"apiv", "rand", "h", "m", "s"} {
+		assert.NotEmpty(t, encoded[name], name)
+	}
+	assert.Len(t, encoded, 7)
+}
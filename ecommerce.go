@@ -0,0 +1,64 @@
+package matomo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TrackOrder sends an ecommerce order using the default client
+func TrackOrder(ctx context.Context, params *Parameters) error {
+	return defaultClient.TrackOrder(ctx, params)
+}
+
+// TrackCartUpdate sends an ecommerce cart update using the default client
+func TrackCartUpdate(ctx context.Context, params *Parameters) error {
+	return defaultClient.TrackCartUpdate(ctx, params)
+}
+
+// TrackOrder sends an ecommerce order to the site id the Client was configured with. The EcommerceParameters must
+// have an OrderID and Revenue, and every item must have a SKU.
+func (c *Client) TrackOrder(ctx context.Context, params *Parameters) error {
+	if params == nil || params.EcommerceParameters == nil {
+		return errors.New("an order requires ecommerce parameters")
+	}
+	ecommerce := params.EcommerceParameters
+	if ecommerce.OrderID == nil || *ecommerce.OrderID == "" {
+		return errors.New("an order requires an order id")
+	}
+	if ecommerce.Revenue == nil {
+		return errors.New("an order requires the revenue")
+	}
+	if err := validateEcommerceItems(ecommerce.Items); err != nil {
+		return err
+	}
+	return c.SendContext(ctx, params)
+}
+
+// TrackCartUpdate sends an ecommerce cart update to the site id the Client was configured with. The
+// EcommerceParameters must have the cart total in Revenue and no OrderID, and every item must have a SKU.
+func (c *Client) TrackCartUpdate(ctx context.Context, params *Parameters) error {
+	if params == nil || params.EcommerceParameters == nil {
+		return errors.New("a cart update requires ecommerce parameters")
+	}
+	ecommerce := params.EcommerceParameters
+	if ecommerce.OrderID != nil {
+		return errors.New("a cart update must not have an order id")
+	}
+	if ecommerce.Revenue == nil {
+		return errors.New("a cart update requires the cart total in revenue")
+	}
+	if err := validateEcommerceItems(ecommerce.Items); err != nil {
+		return err
+	}
+	return c.SendContext(ctx, params)
+}
+
+func validateEcommerceItems(items []EcommerceItem) error {
+	for i, item := range items {
+		if item.SKU == "" {
+			return fmt.Errorf("ecommerce item %d requires a sku", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package matomo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEcommerceParameterEncoding(t *testing.T) {
+	emptyEcommerceParams := &EcommerceParameters{}
+	encoded := mustEncode(t, emptyEcommerceParams)
+	assert.Equal(t, 0, len(encoded))
+
+	encoded = mustEncode(t, testEcommerceParams)
+	assert.Equal(t, 8, len(encoded))
+	assert.Equal(t, "0", encoded["idgoal"])
+	assert.Equal(t, "order-1", encoded["ec_id"])
+	assert.Equal(t, "24.5", encoded["revenue"])
+	assert.Equal(t, "20", encoded["ec_st"])
+	assert.Equal(t, "2", encoded["ec_tx"])
+	assert.Equal(t, "3.5", encoded["ec_sh"])
+	assert.Equal(t, "1", encoded["ec_dt"])
+	assert.Equal(t, `[["SKU-1","Widget","Tools",10,1],["SKU-2","Gadget","Tools",5,2]]`, encoded["ec_items"])
+}
+
+func TestTrackOrderAndCartUpdate(t *testing.T) {
+	received := url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.URL.Query()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, WithSiteID("1"))
+	ctx := context.Background()
+
+	err := client.TrackOrder(ctx, &Parameters{EcommerceParameters: testEcommerceParams})
+	assert.Nil(t, err)
+	assert.Equal(t, "order-1", received.Get("ec_id"))
+	assert.Equal(t, "0", received.Get("idgoal"))
+
+	// orders must have an id and revenue
+	assert.NotNil(t, client.TrackOrder(ctx, &Parameters{}))
+	assert.NotNil(t, client.TrackOrder(ctx, &Parameters{EcommerceParameters: &EcommerceParameters{
+		Revenue: Float64Ptr(1),
+	}}))
+	assert.NotNil(t, client.TrackOrder(ctx, &Parameters{EcommerceParameters: &EcommerceParameters{
+		OrderID: StringPtr("order-2"),
+	}}))
+	assert.NotNil(t, client.TrackOrder(ctx, &Parameters{EcommerceParameters: &EcommerceParameters{
+		OrderID: StringPtr("order-2"),
+		Revenue: Float64Ptr(1),
+		Items:   []EcommerceItem{{Name: "no sku"}},
+	}}))
+
+	err = client.TrackCartUpdate(ctx, &Parameters{EcommerceParameters: &EcommerceParameters{
+		Revenue: Float64Ptr(10),
+		Items:   testEcommerceParams.Items[:1],
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "10", received.Get("revenue"))
+	assert.Empty(t, received.Get("ec_id"))
+
+	// cart updates must not have an order id
+	assert.NotNil(t, client.TrackCartUpdate(ctx, &Parameters{EcommerceParameters: testEcommerceParams}))
+	assert.NotNil(t, client.TrackCartUpdate(ctx, &Parameters{EcommerceParameters: &EcommerceParameters{}}))
+}
+
+var testEcommerceParams = &EcommerceParameters{
+	OrderID: StringPtr("order-1"),
+	Items: []EcommerceItem{
+		{SKU: "SKU-1", Name: "Widget", Category: "Tools", Price: 10, Quantity: 1},
+		{SKU: "SKU-2", Name: "Gadget", Category: "Tools", Price: 5, Quantity: 2},
+	},
+	Revenue:  Float64Ptr(24.5),
+	SubTotal: Float64Ptr(20),
+	Tax:      Float64Ptr(2),
+	Shipping: Float64Ptr(3.5),
+	Discount: Float64Ptr(1),
+}
@@ -0,0 +1,259 @@
+package matomo
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marshaler is implemented by types that encode themselves into a single Matomo parameter value, such as the
+// JSON array used for ecommerce items
+type Marshaler interface {
+	MarshalMatomo() (string, error)
+}
+
+// encodeDefaulter is implemented by parameter structs that fill in values before they are encoded
+type encodeDefaulter interface {
+	setDefaults()
+}
+
+// encodeFinisher is implemented by parameter structs that adjust their encoded values, such as dropping a field
+// that is only valid alongside another one
+type encodeFinisher interface {
+	finishEncode(ret map[string]string)
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// Encode converts a struct, or a pointer to one, into Matomo parameters using the matomo struct tags on its fields.
+// Nil pointers and empty slices and maps are skipped. Supported field types are:
+//
+//   - strings, ints, uints and floats, which are formatted as-is
+//   - bools, which are sent as 1 or 0
+//   - time.Time, which is sent as a UNIX timestamp, and time.Duration, which is sent in milliseconds
+//   - maps with integer keys, which are sent as the tag name followed by the key (eg: dimension1)
+//   - types implementing Marshaler
+//   - nested structs, which are flattened into the result if the field is untagged or tagged ",inline"
+//   - a map with string keys tagged ",extra", whose keys are used as the parameter names
+//
+// Values are returned as-is, not escaped, so they must be escaped once when they are added to a URL, for example by
+// adding them to url.Values. Fields tagged "-" or without a matomo tag are skipped. This is the same encoder used
+// for Parameters, so it can be used for your own structs of extra parameters, which can be sent by adding them to
+// Parameters.Extensions.
+func Encode(v interface{}) (map[string]string, error) {
+	ret := map[string]string{}
+	if v == nil {
+		return ret, nil
+	}
+	err := encodeValue(reflect.ValueOf(v), ret)
+	return ret, err
+}
+
+// encodeValue encodes a struct value into ret, running the struct's encode hooks
+func encodeValue(v reflect.Value, ret map[string]string) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if defaulter, ok := v.Interface().(encodeDefaulter); ok {
+			defaulter.setDefaults()
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot encode %s, only structs can be encoded", v.Type())
+	}
+
+	fields := map[string]string{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		name, options := parseTag(field.Tag.Get("matomo"))
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if options.has("extra") {
+			if err := encodeExtra(fv, fields); err != nil {
+				return fmt.Errorf("%s: %v", field.Name, err)
+			}
+			continue
+		}
+		if options.has("inline") || (name == "" && isNestedStruct(field.Type)) {
+			if err := encodeInline(fv, fields); err != nil {
+				return fmt.Errorf("%s: %v", field.Name, err)
+			}
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		if err := encodeField(name, fv, fields); err != nil {
+			return fmt.Errorf("%s: %v", field.Name, err)
+		}
+	}
+
+	if v.CanAddr() {
+		if finisher, ok := v.Addr().Interface().(encodeFinisher); ok {
+			finisher.finishEncode(fields)
+		}
+	}
+	for k, value := range fields {
+		ret[k] = value
+	}
+	return nil
+}
+
+// encodeInline flattens a nested struct, or each struct in a slice of them, into ret
+func encodeInline(v reflect.Value, ret map[string]string) error {
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(v.Index(i), ret); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return encodeValue(v, ret)
+}
+
+// encodeField encodes a single tagged field into ret under name
+func encodeField(name string, v reflect.Value, ret map[string]string) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	case reflect.Slice, reflect.Map:
+		if v.Len() == 0 {
+			return nil
+		}
+	}
+	if marshaler, ok := v.Interface().(Marshaler); ok {
+		value, err := marshaler.MarshalMatomo()
+		if err != nil {
+			return err
+		}
+		ret[name] = value
+		return nil
+	}
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		return encodeField(name, v.Elem(), ret)
+	}
+	if v.Kind() == reflect.Map {
+		return encodeMap(name, v, ret)
+	}
+	value, err := formatValue(v)
+	if err != nil {
+		return err
+	}
+	ret[name] = value
+	return nil
+}
+
+// encodeMap encodes a map with integer keys, such as custom dimensions, as one parameter per key
+func encodeMap(name string, v reflect.Value, ret map[string]string) error {
+	switch v.Type().Key().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return fmt.Errorf("cannot encode a map with %s keys", v.Type().Key())
+	}
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := formatValue(iter.Key())
+		if err != nil {
+			return err
+		}
+		value, err := formatValue(iter.Value())
+		if err != nil {
+			return err
+		}
+		ret[name+key] = value
+	}
+	return nil
+}
+
+// encodeExtra encodes a map with string keys as one parameter per key, using the key as the parameter name
+func encodeExtra(v reflect.Value, ret map[string]string) error {
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("extra parameters must be a map with string keys, not %s", v.Type())
+	}
+	iter := v.MapRange()
+	for iter.Next() {
+		value, err := formatValue(iter.Value())
+		if err != nil {
+			return err
+		}
+		ret[iter.Key().String()] = value
+	}
+	return nil
+}
+
+// formatValue converts a single value to the string Matomo expects
+func formatValue(v reflect.Value) (string, error) {
+	switch v.Type() {
+	case timeType:
+		return strconv.FormatInt(v.Interface().(time.Time).Unix(), 10), nil
+	case durationType:
+		return strconv.FormatInt(v.Interface().(time.Duration).Milliseconds(), 10), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return "1", nil
+		}
+		return "0", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "", nil
+		}
+		return formatValue(v.Elem())
+	}
+	return "", fmt.Errorf("cannot encode a value of type %s", v.Type())
+}
+
+// tagOptions are the options that follow the name in a matomo tag, such as inline
+type tagOptions []string
+
+func (options tagOptions) has(option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTag splits a matomo tag into the parameter name and its options
+func parseTag(tag string) (string, tagOptions) {
+	parts := strings.Split(tag, ",")
+	return parts[0], tagOptions(parts[1:])
+}
+
+// isNestedStruct returns true if the type is a struct, or pointer to one, that should be flattened
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType
+}
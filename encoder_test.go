@@ -0,0 +1,88 @@
+package matomo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testExtension struct {
+	Plan       *string         `matomo:"plan"`
+	Seats      int             `matomo:"seats"`
+	Trial      bool            `matomo:"trial"`
+	Ratio      float32         `matomo:"ratio"`
+	Since      time.Time       `matomo:"since"`
+	Elapsed    time.Duration   `matomo:"elapsed"`
+	Tags       map[uint]string `matomo:"tag"`
+	Skipped    *string         `matomo:"-"`
+	Untagged   string
+	Nested     *testNested
+	unexported string
+}
+
+type testNested struct {
+	Source *string `matomo:"source"`
+}
+
+func TestEncode(t *testing.T) {
+	since := time.Date(2021, 6, 9, 0, 0, 0, 0, time.UTC)
+	encoded, err := Encode(&testExtension{
+		Plan:       StringPtr("pro plan"),
+		Seats:      5,
+		Ratio:      0.5,
+		Since:      since,
+		Elapsed:    2 * time.Second,
+		Tags:       map[uint]string{3: "beta"},
+		Skipped:    StringPtr("skipped"),
+		Untagged:   "skipped",
+		Nested:     &testNested{Source: StringPtr("newsletter")},
+		unexported: "skipped",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 8, len(encoded))
+	assert.Equal(t, "pro plan", encoded["plan"])
+	assert.Equal(t, "5", encoded["seats"])
+	assert.Equal(t, "0", encoded["trial"])
+	assert.Equal(t, "0.5", encoded["ratio"])
+	assert.Equal(t, "1623196800", encoded["since"])
+	assert.Equal(t, "2000", encoded["elapsed"])
+	assert.Equal(t, "beta", encoded["tag3"])
+	assert.Equal(t, "newsletter", encoded["source"])
+
+	// nil and unsupported values
+	encoded, err = Encode(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(encoded))
+	_, err = Encode("not a struct")
+	assert.NotNil(t, err)
+	_, err = Encode(&struct {
+		Bad []string `matomo:"bad"`
+	}{Bad: []string{"a"}})
+	assert.NotNil(t, err)
+}
+
+func TestEncodeExtensions(t *testing.T) {
+	params := Parameters{
+		EventTrackingParameters: testEventParams,
+		Extensions: []interface{}{
+			&testNested{Source: StringPtr("newsletter")},
+			&struct {
+				Dimension *string `matomo:"dimension9"`
+			}{Dimension: StringPtr("extended")},
+		},
+	}
+	encoded := params.encode()
+	assert.Equal(t, "newsletter", encoded["source"])
+	assert.Equal(t, "extended", encoded["dimension9"])
+	assert.Equal(t, *testEventParams.Category, encoded["e_c"])
+}
+
+func TestEncodeEventRequiresCategoryAndAction(t *testing.T) {
+	encoded := mustEncode(t, &EventTrackingParameters{
+		Category: StringPtr("category"),
+		Name:     StringPtr("name"),
+	})
+	assert.Equal(t, 1, len(encoded))
+	assert.Equal(t, "name", encoded["e_n"])
+}
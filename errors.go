@@ -0,0 +1,96 @@
+package matomo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/go-resty/resty/v2"
+)
+
+var (
+	// ErrNotConfigured is returned when the Client has no domain, such as the default client when MATOMO_DOMAIN
+	// is not set
+	ErrNotConfigured = errors.New("matomo is not configured: the domain was not provided")
+	// ErrMissingSiteID is returned when no site id was passed and the Client has none configured
+	ErrMissingSiteID = errors.New("the site id was not provided")
+	// ErrTimeout is matched by errors.Is when a request's deadline passed or the transport timed out before Matomo
+	// responded. The error still unwraps to the underlying cause, such as context.DeadlineExceeded.
+	ErrTimeout = errors.New("the request to matomo timed out")
+)
+
+// StatusError is returned when Matomo responds with an unexpected status code
+type StatusError struct {
+	// Code is the HTTP status code Matomo responded with
+	Code int
+	// Body is the body of the response
+	Body string
+	// Request is the method and URL of the request, without the token_auth
+	Request string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("invalid status code returned: %d, body was: %+v", e.Code, e.Body)
+}
+
+// Temporary returns true if the status means the request may succeed if it is tried again later, such as a
+// server error or rate limiting. Other errors mean Matomo rejected the request itself.
+func (e *StatusError) Temporary() bool {
+	return e.Code == http.StatusRequestTimeout || e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+// newStatusError builds a StatusError from a response
+func newStatusError(resp *resty.Response) *StatusError {
+	err := &StatusError{
+		Code: resp.StatusCode(),
+		Body: string(resp.Body()),
+	}
+	if resp.Request != nil && resp.Request.RawRequest != nil {
+		raw := resp.Request.RawRequest
+		redacted := *raw.URL
+		query := redacted.Query()
+		if query.Get("token_auth") != "" {
+			query.Del("token_auth")
+			redacted.RawQuery = query.Encode()
+		}
+		err.Request = raw.Method + " " + redacted.String()
+	}
+	return err
+}
+
+// timeoutError is a timeout from either the context or the transport. It matches ErrTimeout and unwraps to the
+// cause.
+type timeoutError struct {
+	cause error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrTimeout, e.cause)
+}
+
+func (e *timeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+func (e *timeoutError) Unwrap() error {
+	return e.cause
+}
+
+// contextError wraps the error from a cancelled or expired context so callers can tell it apart from Matomo errors
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &timeoutError{cause: err}
+	}
+	return fmt.Errorf("the request to matomo was aborted: %w", err)
+}
+
+// transportError marks timeouts from the HTTP client, such as a dial or response header timeout, with ErrTimeout
+func transportError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && !errors.Is(err, ErrTimeout) {
+		return &timeoutError{cause: err}
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package matomo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("1"), WithTokenAuth("secret"))
+	err := client.Send(&Parameters{AuthenticatedParameters: &AuthenticatedParameters{VisitorIP: StringPtr("203.0.113.7")}})
+	statusErr := &StatusError{}
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusBadRequest, statusErr.Code)
+	assert.Equal(t, "bad request", statusErr.Body)
+	assert.False(t, statusErr.Temporary())
+	assert.True(t, strings.HasPrefix(statusErr.Request, "GET "+server.URL+"/matomo.php?"))
+	assert.Contains(t, statusErr.Request, "cip=")
+	assert.NotContains(t, statusErr.Request, "secret")
+	assert.Equal(t, "invalid status code returned: 400, body was: bad request", err.Error())
+
+	_, err = client.SendBulk(context.Background(), []*Parameters{{}})
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, "POST "+server.URL+"/matomo.php", statusErr.Request)
+
+	assert.True(t, (&StatusError{Code: http.StatusServiceUnavailable}).Temporary())
+}
+
+func TestConfigurationErrors(t *testing.T) {
+	err := NewClient("", WithSiteID("1")).Send(&Parameters{})
+	assert.True(t, errors.Is(err, ErrNotConfigured))
+	err = NewClient("https://matomo.example.com").Send(&Parameters{})
+	assert.True(t, errors.Is(err, ErrMissingSiteID))
+	_, err = NewClient("https://matomo.example.com").SendBulk(context.Background(), nil)
+	assert.True(t, errors.Is(err, ErrMissingSiteID))
+	err = NewTracker(NewClient("https://matomo.example.com"), TrackerOptions{}).Track(&Parameters{})
+	assert.True(t, errors.Is(err, ErrMissingSiteID))
+}
+
+func TestTimeoutErrors(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewClient(server.URL, WithSiteID("1"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.SendContext(ctx, &Parameters{})
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// cancelling is not a timeout
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = client.SendContext(ctx, &Parameters{})
+	assert.False(t, errors.Is(err, ErrTimeout))
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	// a timeout in the transport matches too
+	client.http.SetTimeout(50 * time.Millisecond)
+	err = client.Send(&Parameters{})
+	assert.True(t, errors.Is(err, ErrTimeout))
+}
@@ -0,0 +1,98 @@
+package matomo
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives the SDK's log output. keysAndValues are alternating keys and values describing the message, in
+// the style of log/slog, so a *slog.Logger can be used directly or through NewSlogLogger.
+//
+// Debug gets the details of every request sent, Info gets events that are handled for you such as a request that
+// was spooled, Warn gets configuration problems and events that were dropped, and Error gets failures no caller
+// will see, such as a background flush that could not be delivered.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// stdLogger writes to a standard library *log.Logger
+type stdLogger struct {
+	logger *log.Logger
+	debug  bool
+}
+
+// NewStdLogger returns a Logger that writes to logger, or the standard library's default logger if it is nil.
+// Warnings and errors are always written; debug and info messages are only written if debug is true. The
+// Clients use NewStdLogger(nil, false) unless they are given another Logger.
+func NewStdLogger(logger *log.Logger, debug bool) Logger {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &stdLogger{
+		logger: logger,
+		debug:  debug,
+	}
+}
+
+func (l *stdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	if l.debug {
+		l.write("DEBUG", msg, keysAndValues)
+	}
+}
+
+func (l *stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	if l.debug {
+		l.write("INFO", msg, keysAndValues)
+	}
+}
+
+func (l *stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.write("WARN", msg, keysAndValues)
+}
+
+func (l *stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.write("ERROR", msg, keysAndValues)
+}
+
+// write formats the message as "matomo: LEVEL msg key=value ..."
+func (l *stdLogger) write(level, msg string, keysAndValues []interface{}) {
+	line := strings.Builder{}
+	line.WriteString("matomo: " + level + " " + msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			fmt.Fprintf(&line, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+		} else {
+			fmt.Fprintf(&line, " %v", keysAndValues[i])
+		}
+	}
+	l.logger.Print(line.String())
+}
+
+// nopLogger discards everything
+type nopLogger struct{}
+
+// NewNopLogger returns a Logger that discards everything, to silence the SDK
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+// WithLogger sets the Logger the Client, and any Tracker using it, writes to
+func WithLogger(logger Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// SetDefaultLogger sets the Logger used by the default client behind the package-level functions
+func SetDefaultLogger(logger Logger) {
+	defaultClient.logger = logger
+}
@@ -0,0 +1,15 @@
+//go:build go1.21
+// +build go1.21
+
+package matomo
+
+import "log/slog"
+
+// NewSlogLogger returns a Logger that writes to logger, or slog.Default() if it is nil. A *slog.Logger already
+// has the methods of Logger, so this only saves looking that up.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger
+}
@@ -0,0 +1,21 @@
+//go:build go1.21
+// +build go1.21
+
+package matomo
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	logger.Debug("sent tracking request", "status", 204)
+	assert.Contains(t, out.String(), `level=DEBUG msg="sent tracking request" status=204`)
+
+	assert.Equal(t, slog.Default(), NewSlogLogger(nil))
+}
@@ -0,0 +1,125 @@
+package matomo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingLogger keeps every message it receives as "LEVEL msg key=value ..."
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(level, msg string, keysAndValues []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	line := level + " " + msg
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		line += fmt.Sprintf(" %v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	l.messages = append(l.messages, line)
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.record("DEBUG", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.record("INFO", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.record("WARN", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.record("ERROR", msg, keysAndValues)
+}
+
+func (l *recordingLogger) find(prefix string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, message := range l.messages {
+		if strings.HasPrefix(message, prefix) {
+			return message
+		}
+	}
+	return ""
+}
+
+func TestRequestLogging(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"status":"success","tracked":1,"invalid":0}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	logger := &recordingLogger{}
+	client := NewClient(server.URL, WithSiteID("1"), WithTokenAuth("secret"), WithLogger(logger))
+	assert.Nil(t, client.Send(&Parameters{
+		RecommendedParameters:   &RecommendedParameters{ActionName: StringPtr("Home")},
+		AuthenticatedParameters: &AuthenticatedParameters{VisitorIP: StringPtr("203.0.113.7")},
+	}))
+	sent := logger.find("DEBUG sent tracking request")
+	assert.Contains(t, sent, "method=GET url="+server.URL+"/matomo.php?action_name=Home")
+	assert.Contains(t, sent, "status=204 latency=")
+	assert.NotContains(t, sent, "secret")
+
+	_, err := client.SendBulk(context.Background(), []*Parameters{{}})
+	assert.Nil(t, err)
+	assert.Contains(t, logger.find("DEBUG sent bulk tracking request"), "requests=1 status=200")
+}
+
+func TestDropLogging(t *testing.T) {
+	logger := &recordingLogger{}
+	spool, err := NewSpool(t.TempDir(), SpoolOptions{MaxEntries: 1, Logger: logger})
+	assert.Nil(t, err)
+	assert.Nil(t, spool.Add("?a=1"))
+	assert.Nil(t, spool.Add("?a=2"))
+	assert.Equal(t, "WARN dropped the oldest spooled requests to stay within the spool caps dropped=1",
+		logger.find("WARN dropped the oldest"))
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer server.Close()
+	defer close(release)
+	tracker := NewTracker(NewClient(server.URL, WithSiteID("1"), WithLogger(logger)), TrackerOptions{QueueSize: 1})
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.Equal(t, ErrQueueFull, tracker.Track(&Parameters{}))
+	assert.Equal(t, "WARN dropped an event because the tracker queue is full queue_size=1",
+		logger.find("WARN dropped an event"))
+}
+
+func TestStdLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := NewStdLogger(log.New(out, "", 0), false)
+	logger.Debug("hidden")
+	logger.Info("hidden")
+	logger.Warn("something happened", "count", 2, "name", "test")
+	logger.Error("odd number of values", "lonely")
+	assert.Equal(t, "matomo: WARN something happened count=2 name=test\nmatomo: ERROR odd number of values lonely\n", out.String())
+
+	out.Reset()
+	logger = NewStdLogger(log.New(out, "", 0), true)
+	logger.Debug("shown", "key", "value")
+	assert.Equal(t, "matomo: DEBUG shown key=value\n", out.String())
+
+	// the nop logger accepts anything
+	NewNopLogger().Error("discarded", "key", "value")
+}
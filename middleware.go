@@ -0,0 +1,142 @@
+package matomo
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MiddlewareOptions configures the page view tracking done by Middleware. Zero values are replaced with sensible
+// defaults.
+type MiddlewareOptions struct {
+	// Tracker queues the page views to be sent in the background. If nil, each page view is sent in its own
+	// goroutine.
+	Tracker *Tracker
+	// ActionName returns the action_name for the request. Defaults to the request path.
+	ActionName func(r *http.Request) string
+	// UserID returns the ID of the logged in user, usually from the request context, or an empty string if there
+	// isn't one. It is called after the handler, so anything the handler added to the context is not visible.
+	UserID func(r *http.Request) string
+	// IncludePaths, if set, limits tracking to requests whose path starts with one of these prefixes.
+	IncludePaths []string
+	// ExcludePaths are path prefixes that are never tracked, such as health checks or static assets.
+	ExcludePaths []string
+	// TrustForwardedFor uses the X-Forwarded-For, X-Real-IP and X-Forwarded-Proto headers to find the visitor's
+	// IP and the URL they requested. Only enable this behind a load balancer or reverse proxy that sets them.
+	TrustForwardedFor bool
+	// SendTimeout limits how long a page view sent without a Tracker may take. Defaults to 10 seconds.
+	SendTimeout time.Duration
+	// OnError is called with any error from queueing or sending a page view. It may be nil.
+	OnError func(err error)
+}
+
+// Middleware returns net/http middleware that records a page view for every request it handles. The URL,
+// referrer, user agent and language are read from the request, the visitor's IP is added if the client has a
+// TokenAuth, and the time the handler took is sent as the server generation time. Page views are sent
+// asynchronously so they don't slow down the response.
+func Middleware(client *Client, options MiddlewareOptions) func(http.Handler) http.Handler {
+	if options.ActionName == nil {
+		options.ActionName = func(r *http.Request) string {
+			return r.URL.Path
+		}
+	}
+	if options.SendTimeout <= 0 {
+		options.SendTimeout = 10 * time.Second
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !options.tracked(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			params := options.pageView(client, r, time.Since(start))
+			options.send(client, params)
+		})
+	}
+}
+
+// tracked returns true if the path passes the include and exclude rules
+func (options *MiddlewareOptions) tracked(path string) bool {
+	if len(options.IncludePaths) > 0 && !hasPrefix(path, options.IncludePaths) {
+		return false
+	}
+	return !hasPrefix(path, options.ExcludePaths)
+}
+
+// pageView builds the parameters for the request
+func (options *MiddlewareOptions) pageView(client *Client, r *http.Request, latency time.Duration) *Parameters {
+	params := &Parameters{
+		RecommendedParameters: &RecommendedParameters{
+			ActionName: StringPtr(options.ActionName(r)),
+			URL:        StringPtr(requestURL(r, options.TrustForwardedFor)),
+		},
+		UserParameters:            &UserParameters{},
+		PagePerformanceParameters: NewServerTiming(latency),
+	}
+	if referrer := r.Referer(); referrer != "" {
+		params.UserParameters.URLRef = StringPtr(referrer)
+	}
+	if userAgent := r.UserAgent(); userAgent != "" {
+		params.UserParameters.UserAgent = StringPtr(userAgent)
+	}
+	if lang := r.Header.Get("Accept-Language"); lang != "" {
+		params.UserParameters.Lang = StringPtr(lang)
+	}
+	if options.UserID != nil {
+		if userID := options.UserID(r); userID != "" {
+			params.UserParameters.UserID = StringPtr(userID)
+		}
+	}
+	// the visitor IP would be refused without a token
+	if client.config.TokenAuth != "" {
+		params.AuthenticatedParameters = &AuthenticatedParameters{
+			VisitorIP: StringPtr(clientIP(r, options.TrustForwardedFor)),
+		}
+	}
+	return params
+}
+
+// send queues the page view, or sends it in the background if there is no Tracker, reporting any error to OnError
+func (options *MiddlewareOptions) send(client *Client, params *Parameters) {
+	if options.Tracker != nil {
+		options.report(options.Tracker.Track(params))
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), options.SendTimeout)
+		defer cancel()
+		options.report(client.SendContext(ctx, params))
+	}()
+}
+
+func (options *MiddlewareOptions) report(err error) {
+	if err != nil && options.OnError != nil {
+		options.OnError(err)
+	}
+}
+
+// requestURL rebuilds the full URL the visitor requested
+func requestURL(r *http.Request, trustForwardedFor bool) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if trustForwardedFor {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+		}
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func hasPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
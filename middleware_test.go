@@ -0,0 +1,103 @@
+package matomo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUserKey struct{}
+
+func TestMiddleware(t *testing.T) {
+	pageViews := make(chan url.Values, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pageViews <- r.URL.Query()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("1"), WithTokenAuth("secret"))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+	tracked := Middleware(client, MiddlewareOptions{
+		ActionName: func(r *http.Request) string {
+			return "Page / " + strings.TrimPrefix(r.URL.Path, "/")
+		},
+		UserID: func(r *http.Request) string {
+			userID, _ := r.Context().Value(testUserKey{}).(string)
+			return userID
+		},
+		IncludePaths: []string{"/app"},
+		ExcludePaths: []string{"/app/health"},
+		OnError: func(err error) {
+			t.Error(err)
+		},
+	})(handler)
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/app/users?page=2", nil)
+	r.RemoteAddr = "203.0.113.7:4567"
+	r.Header.Set("User-Agent", "TestBrowser/1.0")
+	r.Header.Set("Accept-Language", "eo")
+	r.Header.Set("Referer", "https://search.example.com/")
+	r = r.WithContext(context.WithValue(r.Context(), testUserKey{}, "user@example.com"))
+	w := httptest.NewRecorder()
+	tracked.ServeHTTP(w, r)
+	assert.Equal(t, "ok", w.Body.String())
+
+	select {
+	case pageView := <-pageViews:
+		assert.Equal(t, "Page / app/users", pageView.Get("action_name"))
+		assert.Equal(t, "https://example.com/app/users?page=2", pageView.Get("url"))
+		assert.Equal(t, "https://search.example.com/", pageView.Get("urlref"))
+		assert.Equal(t, "TestBrowser/1.0", pageView.Get("ua"))
+		assert.Equal(t, "eo", pageView.Get("lang"))
+		assert.Equal(t, "203.0.113.7", pageView.Get("cip"))
+		assert.Equal(t, "user@example.com", pageView.Get("uid"))
+		assert.NotEmpty(t, pageView.Get("pf_srv"))
+		assert.Equal(t, pageView.Get("pf_srv"), pageView.Get("gt_ms"))
+	case <-time.After(time.Second):
+		t.Fatal("the page view was not sent")
+	}
+
+	// excluded and non-included paths still reach the handler, but are not tracked
+	for _, path := range []string{"/app/health", "/static/app.js"} {
+		w = httptest.NewRecorder()
+		tracked.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, "ok", w.Body.String())
+	}
+	select {
+	case pageView := <-pageViews:
+		t.Fatalf("an excluded path was tracked: %v", pageView)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMiddlewareWithTracker(t *testing.T) {
+	recorder := &bulkRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("1"))
+	tracker := NewTracker(client, TrackerOptions{FlushInterval: time.Hour})
+	tracked := Middleware(client, MiddlewareOptions{Tracker: tracker})(http.NotFoundHandler())
+	for i := 0; i < 3; i++ {
+		tracked.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	}
+	assert.Equal(t, 3, tracker.Len())
+	assert.Nil(t, tracker.Close(context.Background()))
+
+	sent, _ := recorder.count()
+	assert.Equal(t, 3, sent)
+	values, _ := url.ParseQuery(strings.TrimPrefix(recorder.requests[0], "?"))
+	assert.Equal(t, "/missing", values.Get("action_name"))
+	// without a token, the visitor IP is not sent
+	assert.Empty(t, values.Get("cip"))
+}
@@ -0,0 +1,135 @@
+package matomo
+
+import (
+	"errors"
+	"expvar"
+	"time"
+)
+
+// DropReason describes why events were dropped without being delivered
+type DropReason string
+
+const (
+	// DropQueueFull means a Tracker's queue had no room for the event
+	DropQueueFull DropReason = "queue_full"
+	// DropDeliveryFailed means a Tracker's background flush failed, or Matomo rejected the events as invalid
+	DropDeliveryFailed DropReason = "delivery_failed"
+	// DropSpoolFull means the oldest spooled requests were removed to keep the spool within its caps
+	DropSpoolFull DropReason = "spool_full"
+	// DropSpoolFailed means requests that failed to send could not be written to the spool
+	DropSpoolFailed DropReason = "spool_failed"
+	// DropSpoolRejected means Matomo rejected spooled requests while they were replayed, so they were removed
+	DropSpoolRejected DropReason = "spool_rejected"
+)
+
+// Observer is told about the traffic the SDK sends to Matomo, to feed metrics or tracing. Its methods are called
+// synchronously from the goroutine doing the work, so they must be quick and safe for concurrent use. Embed
+// NopObserver to only implement the methods you need.
+type Observer interface {
+	// RequestStarted is called before a request carrying events is sent, once for all of its attempts
+	RequestStarted(events int)
+	// RequestSucceeded is called when Matomo accepted the request, with the time taken including any retries
+	RequestSucceeded(events int, latency time.Duration)
+	// RequestFailed is called when the request failed after any retries
+	RequestFailed(events int, latency time.Duration, err error)
+	// RequestRetried is called before each retry, with the number of the attempt that failed
+	RequestRetried(attempt int)
+	// QueueDepth is called with the number of events waiting in a Tracker's queue when it changes
+	QueueDepth(depth int)
+	// EventsDropped is called when events are dropped without being delivered
+	EventsDropped(events int, reason DropReason)
+}
+
+// NopObserver ignores everything. Embed it in your own Observer to only implement the methods you need.
+type NopObserver struct{}
+
+func (NopObserver) RequestStarted(events int)                                  {}
+func (NopObserver) RequestSucceeded(events int, latency time.Duration)         {}
+func (NopObserver) RequestFailed(events int, latency time.Duration, err error) {}
+func (NopObserver) RequestRetried(attempt int)                                 {}
+func (NopObserver) QueueDepth(depth int)                                       {}
+func (NopObserver) EventsDropped(events int, reason DropReason)                {}
+
+// WithObserver sets the Observer the Client, and any Tracker using it, reports its traffic to
+func WithObserver(observer Observer) Option {
+	return func(c *Client) {
+		c.observer = observer
+	}
+}
+
+// observe reports the result of a request started at start
+func (c *Client) observe(events int, start time.Time, err error) {
+	if err != nil {
+		c.observer.RequestFailed(events, time.Since(start), err)
+		return
+	}
+	c.observer.RequestSucceeded(events, time.Since(start))
+}
+
+// droppedEvents returns how many of the events in a failed bulk request were dropped, which is only the invalid
+// ones if Matomo tracked the rest
+func droppedEvents(events int, err error) int {
+	var bulkErr *BulkError
+	if errors.As(err, &bulkErr) && bulkErr.Result != nil && bulkErr.Result.Status != "error" {
+		return bulkErr.Result.Invalid
+	}
+	return events
+}
+
+// ExpvarObserver is an Observer that publishes counters with the expvar package, so they are served as JSON on
+// /debug/vars alongside the Go runtime's. The counters are:
+//
+//   - requests_started, requests_succeeded, requests_failed and request_retries
+//   - events_sent and events_failed, the number of events in the succeeded and failed requests
+//   - request_latency_ms, the total time taken by finished requests, to graph the average latency
+//   - queue_depth, the last reported depth of a Tracker's queue
+//   - dropped_queue_full, dropped_delivery_failed, dropped_spool_full, dropped_spool_failed and
+//     dropped_spool_rejected, the number of events dropped for each DropReason
+type ExpvarObserver struct {
+	vars       *expvar.Map
+	queueDepth *expvar.Int
+}
+
+// NewExpvarObserver creates an ExpvarObserver and publishes its counters as a map under name. Like
+// expvar.Publish, it panics if the name is already in use, so create one per process and share it between Clients.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	observer := &ExpvarObserver{
+		vars:       expvar.NewMap(name),
+		queueDepth: &expvar.Int{},
+	}
+	observer.vars.Set("queue_depth", observer.queueDepth)
+	return observer
+}
+
+// Map returns the published counters
+func (o *ExpvarObserver) Map() *expvar.Map {
+	return o.vars
+}
+
+func (o *ExpvarObserver) RequestStarted(events int) {
+	o.vars.Add("requests_started", 1)
+}
+
+func (o *ExpvarObserver) RequestSucceeded(events int, latency time.Duration) {
+	o.vars.Add("requests_succeeded", 1)
+	o.vars.Add("events_sent", int64(events))
+	o.vars.Add("request_latency_ms", latency.Milliseconds())
+}
+
+func (o *ExpvarObserver) RequestFailed(events int, latency time.Duration, err error) {
+	o.vars.Add("requests_failed", 1)
+	o.vars.Add("events_failed", int64(events))
+	o.vars.Add("request_latency_ms", latency.Milliseconds())
+}
+
+func (o *ExpvarObserver) RequestRetried(attempt int) {
+	o.vars.Add("request_retries", 1)
+}
+
+func (o *ExpvarObserver) QueueDepth(depth int) {
+	o.queueDepth.Set(int64(depth))
+}
+
+func (o *ExpvarObserver) EventsDropped(events int, reason DropReason) {
+	o.vars.Add("dropped_"+string(reason), int64(events))
+}
@@ -0,0 +1,206 @@
+package matomo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingObserver counts what it is told
+type recordingObserver struct {
+	NopObserver
+	mu         sync.Mutex
+	started    int
+	succeeded  int
+	failed     int
+	retried    int
+	events     int
+	queueDepth int
+	dropped    map[DropReason]int
+}
+
+func (o *recordingObserver) RequestStarted(events int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.started++
+}
+
+func (o *recordingObserver) RequestSucceeded(events int, latency time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.succeeded++
+	o.events += events
+}
+
+func (o *recordingObserver) RequestFailed(events int, latency time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failed++
+}
+
+func (o *recordingObserver) RequestRetried(attempt int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.retried++
+}
+
+func (o *recordingObserver) QueueDepth(depth int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.queueDepth = depth
+}
+
+func (o *recordingObserver) EventsDropped(events int, reason DropReason) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.dropped == nil {
+		o.dropped = map[DropReason]int{}
+	}
+	o.dropped[reason] += events
+}
+
+func TestObserver(t *testing.T) {
+	calls := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			w.Write([]byte(`{"status":"success","tracked":2,"invalid":0}`))
+		case r.URL.Query().Get("action_name") == "invalid":
+			w.WriteHeader(http.StatusBadRequest)
+		case atomic.AddInt32(&calls, 1) == 1:
+			// the first request is retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	observer := &recordingObserver{}
+	client := NewClient(server.URL, WithSiteID("1"), WithObserver(observer), WithLogger(NewNopLogger()),
+		WithRetryPolicy(policy))
+
+	assert.Nil(t, client.Send(&Parameters{}))
+	assert.NotNil(t, client.Send(&Parameters{RecommendedParameters: &RecommendedParameters{ActionName: StringPtr("invalid")}}))
+	_, err := client.SendBulk(context.Background(), []*Parameters{{}, {}})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 3, observer.started)
+	assert.Equal(t, 2, observer.succeeded)
+	assert.Equal(t, 1, observer.failed)
+	assert.Equal(t, 1, observer.retried)
+	assert.Equal(t, 3, observer.events)
+}
+
+func TestObserverDrops(t *testing.T) {
+	observer := &recordingObserver{}
+	spool, err := NewSpool(t.TempDir(), SpoolOptions{MaxEntries: 1, Observer: observer, Logger: NewNopLogger()})
+	assert.Nil(t, err)
+	assert.Nil(t, spool.Add("?a=1"))
+	assert.Nil(t, spool.Add("?a=2"))
+	assert.Equal(t, 1, observer.dropped[DropSpoolFull])
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer server.Close()
+	defer close(release)
+	client := NewClient(server.URL, WithSiteID("1"), WithObserver(observer), WithLogger(NewNopLogger()))
+	tracker := NewTracker(client, TrackerOptions{QueueSize: 2, FlushInterval: time.Hour})
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.Equal(t, 2, observer.queueDepth)
+	assert.Equal(t, ErrQueueFull, tracker.Track(&Parameters{}))
+	assert.Equal(t, 1, observer.dropped[DropQueueFull])
+}
+
+func TestTrackerDropsAreCountedOnce(t *testing.T) {
+	status := int32(http.StatusBadRequest)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+
+	// events lost when Flush and Close fail are reported
+	observer := &recordingObserver{}
+	client := NewClient(server.URL, WithSiteID("1"), WithObserver(observer), WithLogger(NewNopLogger()))
+	tracker := NewTracker(client, TrackerOptions{FlushInterval: time.Hour})
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.NotNil(t, tracker.Flush(context.Background()))
+	assert.Equal(t, 2, observer.dropped[DropDeliveryFailed])
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.NotNil(t, tracker.Close(context.Background()))
+	assert.Equal(t, 3, observer.dropped[DropDeliveryFailed])
+
+	// events that could not be spooled are only reported by the spool
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	dir := filepath.Join(t.TempDir(), "spool")
+	spool, err := NewSpool(dir, SpoolOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, os.RemoveAll(dir))
+	observer = &recordingObserver{}
+	client = NewClient(server.URL, WithSiteID("1"), WithSpool(spool), WithObserver(observer), WithLogger(NewNopLogger()))
+	tracker = NewTracker(client, TrackerOptions{FlushInterval: time.Hour})
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.NotNil(t, tracker.Close(context.Background()))
+	assert.Equal(t, map[DropReason]int{DropSpoolFailed: 2}, observer.dropped)
+}
+
+func TestSpoolInheritsClientObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	logger := &recordingLogger{}
+	spool, err := NewSpool(t.TempDir(), SpoolOptions{MaxEntries: 1})
+	assert.Nil(t, err)
+	// the spool picks up the client's logger and observer whatever order the options are given in
+	NewClient("https://matomo.mydomain.com", WithSpool(spool), WithObserver(observer), WithLogger(logger))
+	assert.Nil(t, spool.Add("?a=1"))
+	assert.Nil(t, spool.Add("?a=2"))
+	assert.Equal(t, 1, observer.dropped[DropSpoolFull])
+	assert.NotEmpty(t, logger.find("WARN dropped the oldest"))
+
+	// ones set in the options are kept
+	own := &recordingObserver{}
+	spool, err = NewSpool(t.TempDir(), SpoolOptions{MaxEntries: 1, Observer: own, Logger: NewNopLogger()})
+	assert.Nil(t, err)
+	NewClient("https://matomo.mydomain.com", WithSpool(spool), WithObserver(observer))
+	assert.Nil(t, spool.Add("?a=1"))
+	assert.Nil(t, spool.Add("?a=2"))
+	assert.Equal(t, 1, own.dropped[DropSpoolFull])
+	assert.Equal(t, 1, observer.dropped[DropSpoolFull])
+}
+
+func TestExpvarObserver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	observer := NewExpvarObserver("matomo_test")
+	client := NewClient(server.URL, WithSiteID("1"), WithObserver(observer))
+	assert.Nil(t, client.Send(&Parameters{}))
+	observer.QueueDepth(7)
+	observer.EventsDropped(3, DropQueueFull)
+
+	vars := observer.Map()
+	assert.Equal(t, "1", vars.Get("requests_started").String())
+	assert.Equal(t, "1", vars.Get("requests_succeeded").String())
+	assert.Equal(t, "1", vars.Get("events_sent").String())
+	assert.NotNil(t, vars.Get("request_latency_ms"))
+	assert.Equal(t, "7", vars.Get("queue_depth").String())
+	assert.Equal(t, "3", vars.Get("dropped_queue_full").String())
+	assert.Nil(t, vars.Get("requests_failed"))
+}
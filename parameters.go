@@ -3,16 +3,17 @@ package matomo
 // field descriptions are all from the Matomo docs as of 20210609: https://developer.matomo.org/api-reference/tracking-api
 
 import (
-	"fmt"
+	"encoding/json"
 	"math/rand"
 	"net/url"
 	"time"
 )
 
 // Parameters are the content that gets sent to the API. If the field is nil, it is skipped. If it isn't nil, it will be
-// automatically encoded and added to the body of the request. sendImage will be set to false. We have a matomo tag, but
-// aren't currently using it and just using hard coded values. Keep in mind that many of these fields are included for
-// completeness sake and will not likely be known or relevant in a server-side context (eg: the user's resolution).
+// automatically encoded and added to the body of the request. sendImage will be set to false. Each field is encoded
+// using its matomo tag, so adding a parameter only requires adding a tagged field. Keep in mind that many of these
+// fields are included for completeness sake and will not likely be known or relevant in a server-side context (eg:
+// the user's resolution).
 type Parameters struct {
 	RecommendedParameters     *RecommendedParameters
 	UserParameters            *UserParameters
@@ -21,6 +22,12 @@ type Parameters struct {
 	EventTrackingParameters   *EventTrackingParameters
 	ContentTrackingParameters *ContentTrackingParameters
 	EcommerceParameters       *EcommerceParameters
+	AuthenticatedParameters   *AuthenticatedParameters
+	// Extensions are your own structs of extra parameters, encoded using their matomo struct tags. See Encode.
+	Extensions []interface{} `matomo:",inline"`
+	// Extra holds any other parameters by name. ParseParameters puts every parameter it doesn't recognize here.
+	// These are sent last, so they replace any value of the same name from the fields above.
+	Extra map[string]string `matomo:",extra"`
 }
 
 // RecommendedParameters are the recommended parameters that really should be provided on each call if available
@@ -53,6 +60,18 @@ type UserParameters struct {
 	CampaignName *string `json:"_rcn" matomo:"_rcn"`
 	// The Campaign Keyword. Used to populate the Referrers > Campaigns report (clicking on a campaign loads all keywords for this campaign). Note: this parameter will only be used for the first pageview of a visit.
 	CampaignKeyword *string `json:"_rck" matomo:"_rck"`
+	// The Campaign Source. Requires the MarketingCampaignsReporting plugin. Note: this parameter will only be used for the first pageview of a visit.
+	CampaignSource *string `json:"_rcs" matomo:"_rcs"`
+	// The Campaign Medium, eg email or cpc. Requires the MarketingCampaignsReporting plugin. Note: this parameter will only be used for the first pageview of a visit.
+	CampaignMedium *string `json:"_rcm" matomo:"_rcm"`
+	// The Campaign Content, used to tell apart ads or links that point to the same URL. Requires the MarketingCampaignsReporting plugin. Note: this parameter will only be used for the first pageview of a visit.
+	CampaignContent *string `json:"_rcc" matomo:"_rcc"`
+	// The Campaign ID. Requires the MarketingCampaignsReporting plugin. Note: this parameter will only be used for the first pageview of a visit.
+	CampaignID *string `json:"_rci" matomo:"_rci"`
+	// The Campaign Group. Requires the MarketingCampaignsReporting plugin. Note: this parameter will only be used for the first pageview of a visit.
+	CampaignGroup *string `json:"_rcg" matomo:"_rcg"`
+	// The Campaign Placement. Requires the MarketingCampaignsReporting plugin. Note: this parameter will only be used for the first pageview of a visit.
+	CampaignPlacement *string `json:"_rcp" matomo:"_rcp"`
 	//  The resolution of the device the visitor is using, eg 1280x1024.
 	Resolution *string `json:"res" matomo:"res"`
 	// The current hour (local time). The SDK will automatically set this if you don't.
@@ -62,7 +81,7 @@ type UserParameters struct {
 	// The current second (local time). The SDK will automatically set this if you don't.
 	CurrentSecond *string `json:"s" matomo:"s"`
 	// Various user plugins that the server likely won't know about.
-	UserPlugins *UserPlugins `json:"plugins" matomo:"-"`
+	UserPlugins *UserPlugins `json:"plugins" matomo:",inline"`
 	// When set to 1, the visitor's client is known to support cookies.
 	CookiesSupported *bool `json:"cookie" matomo:"cookie"`
 	// An override value for the User-Agent HTTP header field. The user agent is used to detect the operating system and browser used.
@@ -87,10 +106,51 @@ type UserPlugins struct {
 	Gears       *bool `json:"gears" matomo:"gears"`
 	Silverlight *bool `json:"ag" matomo:"ag"`
 }
+
+// ActionParameters describe the kind of action being tracked, such as a download, outlink, site search or goal
+// conversion, along with any custom dimensions.
 type ActionParameters struct {
+	// An external URL the user clicked on. Used for tracking outlink clicks. We recommend to also set the url parameter to this same value.
+	Link *string `json:"link" matomo:"link"`
+	// URL of a file the user has downloaded. Used for tracking downloads. We recommend to also set the url parameter to this same value.
+	Download *string `json:"download" matomo:"download"`
+	// The Site Search keyword. When specified, the request will not be tracked as a normal pageview but will instead be tracked as a Site Search request.
+	Search *string `json:"search" matomo:"search"`
+	// When Search is specified, you can optionally specify a search category with this parameter.
+	SearchCategory *string `json:"search_cat" matomo:"search_cat"`
+	// When Search is specified, we also recommend setting the search_count to the number of search results displayed on the results page. When keywords are tracked with &search_count=0 they will appear in the "No Result Search Keyword" report.
+	SearchCount *int64 `json:"search_count" matomo:"search_count"`
+	// Accepts a six character unique ID that identifies which actions were performed on a specific page view. When a page was viewed, all following tracking requests (such as events) during that page view should use the same pageview ID. Once another page was viewed a new unique ID should be generated.
+	PageViewID *string `json:"pv_id" matomo:"pv_id"`
+	// If specified, the tracking request will trigger a conversion for the goal of the website being tracked with this ID.
+	GoalID *int64 `json:"idgoal" matomo:"idgoal"`
+	// A monetary value that was generated as revenue by this goal conversion. Only used if idgoal is specified in the request.
+	Revenue *float64 `json:"revenue" matomo:"revenue"`
+	// Stands for custom action. When set to 1, the request will not be tracked as a pageview but as a custom action. This is useful when the url parameter is a custom URL that does not represent a page on the site.
+	CustomAction *bool `json:"ca" matomo:"ca"`
+	// The charset of the page being tracked. Specify the charset if the data you send to Matomo is encoded in a different character set than the default utf-8.
+	Charset *string `json:"cs" matomo:"cs"`
+	// Custom dimension values keyed by the dimension ID, which are sent as dimension[ID] (eg: dimension1). The Custom Dimensions plugin must be installed.
+	Dimensions map[int]string `json:"dimensions" matomo:"dimension"`
 }
 
+// PagePerformanceParameters report how long the page took to load, in milliseconds. Server-side, the server time is
+// usually the only one known; NewServerTiming sets it from a time.Duration.
 type PagePerformanceParameters struct {
+	// Network time. How long it took to connect to the server.
+	NetworkTime *int64 `json:"pf_net" matomo:"pf_net"`
+	// Server time. How long it took the server to generate the page.
+	ServerTime *int64 `json:"pf_srv" matomo:"pf_srv"`
+	// Transfer time. How long it takes the browser to download the response from the server.
+	TransferTime *int64 `json:"pf_tfr" matomo:"pf_tfr"`
+	// Dom processing time. How long the browser spends loading the webpage after the response was fully received until the user can start interacting with it.
+	DOMProcessingTime *int64 `json:"pf_dm1" matomo:"pf_dm1"`
+	// Dom completion time. How long it takes for the browser to load media and execute any Javascript code listening for the DOMContentLoaded event.
+	DOMCompletionTime *int64 `json:"pf_dm2" matomo:"pf_dm2"`
+	// Onload time. How long it takes the browser to execute Javascript code waiting for the window.load event.
+	OnloadTime *int64 `json:"pf_onl" matomo:"pf_onl"`
+	// The time in milliseconds it took to generate the page. Deprecated by Matomo in favor of the pf_srv value, but still read by older installations.
+	GenerationTime *int64 `json:"gt_ms" matomo:"gt_ms"`
 }
 
 // EventTrackParameters add context to a user's actions on your platform.
@@ -105,10 +165,106 @@ type EventTrackingParameters struct {
 	Value *float64 `json:"e_v" matomo:"e_v"`
 }
 
+// ContentTrackingParameters record impressions of and interactions with content blocks, such as banners or ads.
+// Use NewContentImpression and NewContentInteraction to build them.
 type ContentTrackingParameters struct {
+	// The name of the content. For instance 'Ad Foo Bar'. Required for both impressions and interactions.
+	Name *string `json:"c_n" matomo:"c_n"`
+	// The actual content piece. For instance the path to an image, video, audio, any text
+	Piece *string `json:"c_p" matomo:"c_p"`
+	// The target of the content. For instance the URL of a landing page
+	Target *string `json:"c_t" matomo:"c_t"`
+	// The name of the interaction with the content. For instance a 'click'. Only set for content interactions.
+	Interaction *string `json:"c_i" matomo:"c_i"`
 }
 
+// EcommerceParameters track ecommerce orders and cart updates. When any of them are set, idgoal=0 is sent for you
+// so Matomo records the request as an ecommerce interaction. Use TrackOrder and TrackCartUpdate to make sure the
+// required fields are set.
 type EcommerceParameters struct {
+	// The unique string identifier for the ecommerce order (required when tracking an ecommerce order). Do not set it when tracking a cart update.
+	OrderID *string `json:"ec_id" matomo:"ec_id"`
+	// The items in the ecommerce order or cart.
+	Items EcommerceItems `json:"ec_items" matomo:"ec_items"`
+	// The grand total for the ecommerce order or the current value of the cart (required when tracking an ecommerce order or cart update).
+	Revenue *float64 `json:"revenue" matomo:"revenue"`
+	// The sub total of the order; excludes shipping.
+	SubTotal *float64 `json:"ec_st" matomo:"ec_st"`
+	// Tax amount of the order.
+	Tax *float64 `json:"ec_tx" matomo:"ec_tx"`
+	// Shipping cost of the order.
+	Shipping *float64 `json:"ec_sh" matomo:"ec_sh"`
+	// Discount offered.
+	Discount *float64 `json:"ec_dt" matomo:"ec_dt"`
+}
+
+// EcommerceItems are the products in an ecommerce order or cart
+type EcommerceItems []EcommerceItem
+
+// EcommerceItem is a single product in an ecommerce order or cart
+type EcommerceItem struct {
+	// The product SKU. Required.
+	SKU string `json:"sku"`
+	// The product name.
+	Name string `json:"name"`
+	// The product category.
+	Category string `json:"category"`
+	// The price of a single unit of the product.
+	Price float64 `json:"price"`
+	// The number of units.
+	Quantity int64 `json:"quantity"`
+}
+
+// NewContentImpression builds the parameters to record that a content block was shown. The piece and target are
+// optional and are left out if empty.
+func NewContentImpression(name, piece, target string) *ContentTrackingParameters {
+	params := &ContentTrackingParameters{
+		Name: StringPtr(name),
+	}
+	if piece != "" {
+		params.Piece = StringPtr(piece)
+	}
+	if target != "" {
+		params.Target = StringPtr(target)
+	}
+	return params
+}
+
+// NewContentInteraction builds the parameters to record an interaction, such as a click, with a content block. The
+// piece and target are optional and are left out if empty, but should match the ones used for the impression.
+func NewContentInteraction(interaction, name, piece, target string) *ContentTrackingParameters {
+	params := NewContentImpression(name, piece, target)
+	params.Interaction = StringPtr(interaction)
+	return params
+}
+
+// NewServerTiming builds the page performance parameters for a page the server took the provided duration to
+// generate. Both pf_srv and the older gt_ms are set so any version of Matomo will report it.
+func NewServerTiming(generation time.Duration) *PagePerformanceParameters {
+	return &PagePerformanceParameters{
+		ServerTime:     MillisecondsPtr(generation),
+		GenerationTime: MillisecondsPtr(generation),
+	}
+}
+
+// AuthenticatedParameters override values Matomo would normally detect from the request, such as the visitor's IP
+// or the time of the visit. Matomo only accepts them on requests authenticated with a token_auth, so the client
+// refuses to send them unless a TokenAuth is configured. The one exception is a RequestTime in the last 24 hours.
+type AuthenticatedParameters struct {
+	// Override value for the visitor IP (both IPv4 and IPv6 notations supported).
+	VisitorIP *string `json:"cip" matomo:"cip"`
+	// Override for the datetime of the request (normally the current time is used). This can be used to record visits and page views in the past.
+	RequestTime *time.Time `json:"cdt" matomo:"cdt"`
+	// An override value for the country. Should be set to the two letter country code of the visitor (lowercase), eg fr, de, us.
+	Country *string `json:"country" matomo:"country"`
+	// An override value for the region. Should be set to a ISO 3166-2 region code, which are used by MaxMind's and DB-IP's GeoIP2 databases.
+	Region *string `json:"region" matomo:"region"`
+	// An override value for the city. The name of the city the visitor is located in, eg, Tokyo.
+	City *string `json:"city" matomo:"city"`
+	// An override value for the visitor's latitude, eg 22.456.
+	Latitude *float64 `json:"lat" matomo:"lat"`
+	// An override value for the visitor's longitude, eg 22.456.
+	Longitude *float64 `json:"long" matomo:"long"`
 }
 
 // StringPtr converts a static string to a pointer for use in the api
@@ -121,6 +277,16 @@ func Int64Ptr(input int64) *int64 {
 	return &input
 }
 
+// MillisecondsPtr converts a duration to a pointer to its whole number of milliseconds for use in the api
+func MillisecondsPtr(input time.Duration) *int64 {
+	return Int64Ptr(input.Milliseconds())
+}
+
+// TimePtr converts a static time to a pointer for use in the api
+func TimePtr(input time.Time) *time.Time {
+	return &input
+}
+
 // BoolPtr converts a static bool to a pointer for use in the api
 func BoolPtr(input bool) *bool {
 	return &input
@@ -132,223 +298,101 @@ func Float64Ptr(input float64) *float64 {
 }
 
 //
-// below, we set up all of the encoders for the structs to convert them into
-// map[string]string for embedding in the URL
+// below, we set up the encoders for the structs to convert them into map[string]string for embedding in the URL.
+// The encoding itself is driven by the matomo struct tags (see Encode); the methods here only hold the rules
+// that tags can't express.
 
+// encode encodes the parameters, leaving out any extension that can't be encoded
 func (params *Parameters) encode() map[string]string {
-	ret := map[string]string{}
-	if params == nil {
-		return ret
-	}
-	// loop through the fields
-	if params.RecommendedParameters != nil {
-		subRet := params.RecommendedParameters.encode()
-		for k, v := range subRet {
-			ret[k] = v
-		}
-	}
-	if params.UserParameters != nil {
-		subRet := params.UserParameters.encode()
-		for k, v := range subRet {
-			ret[k] = v
-		}
-	}
-	if params.EventTrackingParameters != nil {
-		subRet := params.EventTrackingParameters.encode()
-		for k, v := range subRet {
-			ret[k] = v
-		}
-	}
-
+	ret, _ := Encode(params)
 	return ret
 }
 
-func (params *RecommendedParameters) encode() map[string]string {
-	ret := map[string]string{}
-	if params == nil {
-		return ret
-	}
-	// set the required constants
+// Values returns the parameters as url.Values for the provided site, including the idsite and rec=1 parameters every
+// tracking request needs. They are encoded the same way the Client encodes them, but the Client may add more: its
+// token_auth, a cdt when it retries or spools requests, the visit details from its visitor store and its own Rec.
+// Extensions that cannot be encoded are left out; call Encode to see the error.
+func (params *Parameters) Values(siteID string) url.Values {
+	values := toValues(params.encode())
+	values.Set("idsite", siteID)
+	values.Set("rec", "1")
+	return values
+}
+
+// QueryString returns the encoded parameters, sorted by name and escaped once, without a leading ? or the idsite
+// and rec parameters. It can be appended to a matomo.php URL, or used as one of the requests in a bulk request.
+func (params *Parameters) QueryString() string {
+	return toValues(params.encode()).Encode()
+}
+
+// setDefaults sets the required constants
+func (params *RecommendedParameters) setDefaults() {
 	params.APIV = Int64Ptr(1)
 	if params.Rand == nil {
 		params.Rand = Int64Ptr(rand.Int63n(99999999999999999))
 	}
-	// loop through the fields
-	ret["apiv"] = url.QueryEscape(fmt.Sprintf("%v", *params.APIV))
-	ret["rand"] = url.QueryEscape(fmt.Sprintf("%v", *params.Rand))
-	if params.ActionName != nil {
-		ret["action_name"] = url.QueryEscape(*params.ActionName)
-	}
-	if params.VisitorID != nil {
-		ret["_id"] = url.QueryEscape(*params.VisitorID)
-	}
-	if params.URL != nil {
-		ret["url"] = url.QueryEscape(*params.URL)
-	}
-
-	return ret
 }
 
-func (params *UserParameters) encode() map[string]string {
-	ret := map[string]string{}
-	if params == nil {
-		return ret
-	}
+// finishEncode fills in the local time of the request if it wasn't provided
+func (params *UserParameters) finishEncode(ret map[string]string) {
 	now := time.Now()
-	// convert the fields
-	if params.URLRef != nil {
-		ret["urlref"] = url.QueryEscape(*params.URLRef)
-	}
-	if params.CVar != nil {
-		ret["_cvar"] = url.QueryEscape(*params.CVar)
+	if _, ok := ret["h"]; !ok {
+		ret["h"] = now.Format("15")
 	}
-	if params.IDVC != nil {
-		ret["_idvc"] = url.QueryEscape(fmt.Sprintf("%v", *params.IDVC))
+	if _, ok := ret["m"]; !ok {
+		ret["m"] = now.Format("04")
 	}
-	if params.ViewTS != nil {
-		ret["_viewts"] = url.QueryEscape(fmt.Sprintf("%v", *params.ViewTS))
-	}
-	if params.IDTS != nil {
-		ret["_idts"] = url.QueryEscape(fmt.Sprintf("%v", *params.IDTS))
-	}
-	if params.CampaignName != nil {
-		ret["_rcn"] = url.QueryEscape(*params.CampaignName)
-	}
-	if params.CampaignKeyword != nil {
-		ret["_rck"] = url.QueryEscape(*params.CampaignKeyword)
-	}
-	if params.Resolution != nil {
-		ret["res"] = url.QueryEscape(*params.Resolution)
-	}
-	if params.CurrentHour != nil {
-		ret["h"] = url.QueryEscape(*params.CurrentHour)
-	} else {
-		ret["h"] = url.QueryEscape(now.Format("15"))
-	}
-	if params.CurrentMinute != nil {
-		ret["m"] = url.QueryEscape(*params.CurrentMinute)
-	} else {
-		ret["m"] = url.QueryEscape(now.Format("04"))
-	}
-	if params.CurrentSecond != nil {
-		ret["s"] = url.QueryEscape(*params.CurrentSecond)
-	} else {
-		ret["s"] = url.QueryEscape(now.Format("05"))
-	}
-	if params.CookiesSupported != nil {
-		if *params.CookiesSupported {
-			ret["cookie"] = url.QueryEscape("1")
-		} else {
-			ret["cookie"] = url.QueryEscape("0")
-		}
-	}
-	if params.Lang != nil {
-		ret["lang"] = url.QueryEscape(*params.Lang)
-	}
-	if params.UserID != nil {
-		ret["uid"] = url.QueryEscape(*params.UserID)
-	}
-	if params.NewVisit != nil {
-		ret["new_visit"] = url.QueryEscape("1")
+	if _, ok := ret["s"]; !ok {
+		ret["s"] = now.Format("05")
 	}
+}
 
-	// now plugins
-	plugins := params.UserPlugins.encode()
-	for k, v := range plugins {
-		ret[k] = v
+// finishEncode drops the category and action unless both are provided, since both are required
+func (params *EventTrackingParameters) finishEncode(ret map[string]string) {
+	if params.Action == nil || params.Category == nil {
+		delete(ret, "e_c")
+		delete(ret, "e_a")
 	}
+}
 
-	return ret
+// decoded only keeps parsed event parameters with both a category and an action, so finishEncode keeps them too
+func (params *EventTrackingParameters) decoded(values url.Values) bool {
+	return params.Category != nil && params.Action != nil
 }
 
-func (params *UserPlugins) encode() map[string]string {
-	ret := map[string]string{}
-	if params == nil {
-		return ret
-	}
-	if params.Flash != nil {
-		if *params.Flash {
-			ret["fla"] = url.QueryEscape("1")
-		} else {
-			ret["fla"] = url.QueryEscape("0")
-		}
-	}
-	if params.Java != nil {
-		if *params.Java {
-			ret["java"] = url.QueryEscape("1")
-		} else {
-			ret["java"] = url.QueryEscape("0")
-		}
-	}
-	if params.Director != nil {
-		if *params.Director {
-			ret["dir"] = url.QueryEscape("1")
-		} else {
-			ret["dir"] = url.QueryEscape("0")
-		}
-	}
-	if params.Quicktime != nil {
-		if *params.Quicktime {
-			ret["qt"] = url.QueryEscape("1")
-		} else {
-			ret["qt"] = url.QueryEscape("0")
-		}
-	}
-	if params.RealPlayer != nil {
-		if *params.RealPlayer {
-			ret["realp"] = url.QueryEscape("1")
-		} else {
-			ret["realp"] = url.QueryEscape("0")
-		}
-	}
-	if params.PDF != nil {
-		if *params.PDF {
-			ret["pdf"] = url.QueryEscape("1")
-		} else {
-			ret["pdf"] = url.QueryEscape("0")
-		}
-	}
-	if params.WMA != nil {
-		if *params.WMA {
-			ret["wma"] = url.QueryEscape("1")
-		} else {
-			ret["wma"] = url.QueryEscape("0")
-		}
-	}
-	if params.Gears != nil {
-		if *params.Gears {
-			ret["gears"] = url.QueryEscape("1")
-		} else {
-			ret["gears"] = url.QueryEscape("0")
-		}
-	}
-	if params.Silverlight != nil {
-		if *params.Silverlight {
-			ret["ag"] = url.QueryEscape("1")
-		} else {
-			ret["ag"] = url.QueryEscape("0")
+// finishEncode drops everything if there is no name, since it is required for both impressions and interactions
+func (params *ContentTrackingParameters) finishEncode(ret map[string]string) {
+	if params.Name == nil {
+		for k := range ret {
+			delete(ret, k)
 		}
 	}
+}
 
-	return ret
+// decoded only keeps parsed content parameters with a name, so finishEncode keeps them too
+func (params *ContentTrackingParameters) decoded(values url.Values) bool {
+	return params.Name != nil
 }
 
-func (params *EventTrackingParameters) encode() map[string]string {
-	ret := map[string]string{}
-	if params == nil {
-		return ret
-	}
-	// both Action and Category are required
-	if params.Action != nil && params.Category != nil {
-		ret["e_c"] = url.QueryEscape(*params.Category)
-		ret["e_a"] = url.QueryEscape(*params.Action)
-	}
-	if params.Name != nil {
-		ret["e_n"] = url.QueryEscape(*params.Name)
-	}
-	if params.Value != nil {
-		ret["e_v"] = url.QueryEscape(fmt.Sprintf("%v", *params.Value))
+// finishEncode records ecommerce interactions against the special goal 0
+func (params *EcommerceParameters) finishEncode(ret map[string]string) {
+	if len(ret) > 0 {
+		ret["idgoal"] = "0"
 	}
+}
 
-	return ret
+// decoded only keeps parsed ecommerce parameters for ecommerce interactions, since revenue is shared with goals
+func (params *EcommerceParameters) decoded(values url.Values) bool {
+	return values.Get("idgoal") == "0"
+}
+
+// MarshalMatomo converts the items to the JSON array of arrays Matomo expects, eg:
+// [["SKU","Name","Category",9.99,2]]
+func (items EcommerceItems) MarshalMatomo() (string, error) {
+	rows := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, []interface{}{item.SKU, item.Name, item.Category, item.Price, item.Quantity})
+	}
+	encoded, err := json.Marshal(rows)
+	return string(encoded), err
 }
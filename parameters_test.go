@@ -10,6 +10,14 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// mustEncode encodes a parameter struct, failing the test if it can't be encoded
+func mustEncode(t *testing.T, v interface{}) map[string]string {
+	t.Helper()
+	encoded, err := Encode(v)
+	assert.Nil(t, err)
+	return encoded
+}
+
 func TestAllEncoding(t *testing.T) {
 	Setup()
 	// start with just the recommended parameters
@@ -31,23 +39,23 @@ func TestAllEncoding(t *testing.T) {
 	assert.Empty(t, encoded["_rck"])
 
 	encoded = testAllParams.encode()
-	assert.Equal(t, 30, len(encoded)) // this will increase as more fields are supported
+	assert.Equal(t, 31, len(encoded)) // this will increase as more fields are supported
 }
 
 func TestUserParameterEncoding(t *testing.T) {
 	Setup()
 	emptyUserParams := &UserParameters{}
-	encoded := emptyUserParams.encode()
+	encoded := mustEncode(t, emptyUserParams)
 	// the times should be set to the current server time automatically
 	assert.Equal(t, 3, len(encoded))
 	// populate all the fields and encode
-	encoded = testUserParams.encode()
-	assert.Equal(t, 24, len(encoded))
+	encoded = mustEncode(t, testUserParams)
+	assert.Equal(t, 25, len(encoded))
 
 	assert.Equal(t, fmt.Sprintf("%d", *testUserParams.IDTS), encoded["_idts"])
 	assert.Equal(t, fmt.Sprintf("%d", *testUserParams.ViewTS), encoded["_viewts"])
 	assert.Equal(t, "1", encoded["_idvc"])
-	assert.Equal(t, "Keyword+Test", encoded["_rck"])
+	assert.Equal(t, "Keyword Test", encoded["_rck"])
 	assert.Equal(t, "Testing", encoded["_rcn"])
 	assert.Equal(t, "0", encoded["ag"])
 	assert.Equal(t, "1", encoded["cookie"])
@@ -62,21 +70,127 @@ func TestUserParameterEncoding(t *testing.T) {
 	assert.Equal(t, "0", encoded["realp"])
 	assert.Equal(t, "1x1", encoded["res"])
 	assert.Equal(t, "test-user", encoded["uid"])
-	assert.Equal(t, "%2Fusers", encoded["urlref"])
+	assert.Equal(t, "ServerTest", encoded["ua"])
+	assert.Equal(t, "/users", encoded["urlref"])
 	assert.Equal(t, "1", encoded["wma"])
 
 }
 func TestEventParameterEncodings(t *testing.T) {
 	Setup()
 	emptyEventParams := &EventTrackingParameters{}
-	encoded := emptyEventParams.encode()
+	encoded := mustEncode(t, emptyEventParams)
 	assert.Equal(t, 0, len(encoded))
 	// populate all the fields and encode
-	encoded = testEventParams.encode()
-	assert.Equal(t, url.QueryEscape(*testEventParams.Category), encoded["e_c"])
-	assert.Equal(t, url.QueryEscape(*testEventParams.Action), encoded["e_a"])
-	assert.Equal(t, url.QueryEscape(*testEventParams.Name), encoded["e_n"])
-	assert.Equal(t, url.QueryEscape(fmt.Sprintf("%v", *testEventParams.Value)), encoded["e_v"])
+	encoded = mustEncode(t, testEventParams)
+	assert.Equal(t, *testEventParams.Category, encoded["e_c"])
+	assert.Equal(t, *testEventParams.Action, encoded["e_a"])
+	assert.Equal(t, *testEventParams.Name, encoded["e_n"])
+	assert.Equal(t, fmt.Sprintf("%v", *testEventParams.Value), encoded["e_v"])
+}
+
+func TestContentParameterEncodings(t *testing.T) {
+	emptyContentParams := &ContentTrackingParameters{}
+	encoded := mustEncode(t, emptyContentParams)
+	assert.Equal(t, 0, len(encoded))
+
+	// a piece without a name is not a valid content impression
+	encoded = mustEncode(t, &ContentTrackingParameters{Piece: StringPtr("/banner.png")})
+	assert.Equal(t, 0, len(encoded))
+
+	encoded = mustEncode(t, NewContentImpression("Spring Sale", "/banner.png", ""))
+	assert.Equal(t, 2, len(encoded))
+	assert.Equal(t, "Spring Sale", encoded["c_n"])
+	assert.Equal(t, "/banner.png", encoded["c_p"])
+	assert.Empty(t, encoded["c_i"])
+
+	encoded = mustEncode(t, NewContentInteraction("click", "Spring Sale", "/banner.png", "https://example.com/sale"))
+	assert.Equal(t, 4, len(encoded))
+	assert.Equal(t, "click", encoded["c_i"])
+	assert.Equal(t, "https://example.com/sale", encoded["c_t"])
+}
+
+func TestActionParameterEncodings(t *testing.T) {
+	emptyActionParams := &ActionParameters{}
+	encoded := mustEncode(t, emptyActionParams)
+	assert.Equal(t, 0, len(encoded))
+
+	encoded = mustEncode(t, testActionParams)
+	assert.Equal(t, 12, len(encoded))
+	assert.Equal(t, "https://example.com/out", encoded["link"])
+	assert.Equal(t, "https://example.com/file.pdf", encoded["download"])
+	assert.Equal(t, "widgets", encoded["search"])
+	assert.Equal(t, "products", encoded["search_cat"])
+	assert.Equal(t, "0", encoded["search_count"])
+	assert.Equal(t, "abc123", encoded["pv_id"])
+	assert.Equal(t, "2", encoded["idgoal"])
+	assert.Equal(t, "9.99", encoded["revenue"])
+	assert.Equal(t, "1", encoded["ca"])
+	assert.Equal(t, "utf-8", encoded["cs"])
+	assert.Equal(t, "free", encoded["dimension1"])
+	assert.Equal(t, "EU West", encoded["dimension12"])
+
+	// ecommerce interactions always use goal 0, even if a goal was set on the action
+	all := Parameters{
+		ActionParameters:    testActionParams,
+		EcommerceParameters: testEcommerceParams,
+	}
+	encoded = all.encode()
+	assert.Equal(t, "0", encoded["idgoal"])
+	assert.Equal(t, "24.5", encoded["revenue"])
+}
+
+func TestPagePerformanceParameterEncodings(t *testing.T) {
+	emptyPerformanceParams := &PagePerformanceParameters{}
+	encoded := mustEncode(t, emptyPerformanceParams)
+	assert.Equal(t, 0, len(encoded))
+
+	encoded = mustEncode(t, &PagePerformanceParameters{
+		NetworkTime:       Int64Ptr(1),
+		ServerTime:        Int64Ptr(2),
+		TransferTime:      Int64Ptr(3),
+		DOMProcessingTime: Int64Ptr(4),
+		DOMCompletionTime: Int64Ptr(5),
+		OnloadTime:        Int64Ptr(6),
+		GenerationTime:    Int64Ptr(7),
+	})
+	assert.Equal(t, 7, len(encoded))
+	assert.Equal(t, "1", encoded["pf_net"])
+	assert.Equal(t, "2", encoded["pf_srv"])
+	assert.Equal(t, "3", encoded["pf_tfr"])
+	assert.Equal(t, "4", encoded["pf_dm1"])
+	assert.Equal(t, "5", encoded["pf_dm2"])
+	assert.Equal(t, "6", encoded["pf_onl"])
+	assert.Equal(t, "7", encoded["gt_ms"])
+
+	encoded = mustEncode(t, NewServerTiming(150*time.Millisecond+400*time.Microsecond))
+	assert.Equal(t, 2, len(encoded))
+	assert.Equal(t, "150", encoded["pf_srv"])
+	assert.Equal(t, "150", encoded["gt_ms"])
+}
+
+func TestAuthenticatedParameterEncodings(t *testing.T) {
+	emptyAuthenticatedParams := &AuthenticatedParameters{}
+	encoded := mustEncode(t, emptyAuthenticatedParams)
+	assert.Equal(t, 0, len(encoded))
+
+	requestTime := time.Date(2021, 6, 9, 12, 0, 0, 0, time.UTC)
+	encoded = mustEncode(t, &AuthenticatedParameters{
+		VisitorIP:   StringPtr("2001:db8::1"),
+		RequestTime: TimePtr(requestTime),
+		Country:     StringPtr("us"),
+		Region:      StringPtr("US-NY"),
+		City:        StringPtr("New York"),
+		Latitude:    Float64Ptr(40.7128),
+		Longitude:   Float64Ptr(-74.006),
+	})
+	assert.Equal(t, 7, len(encoded))
+	assert.Equal(t, "2001:db8::1", encoded["cip"])
+	assert.Equal(t, fmt.Sprintf("%d", requestTime.Unix()), encoded["cdt"])
+	assert.Equal(t, "us", encoded["country"])
+	assert.Equal(t, "US-NY", encoded["region"])
+	assert.Equal(t, "New York", encoded["city"])
+	assert.Equal(t, "40.7128", encoded["lat"])
+	assert.Equal(t, "-74.006", encoded["long"])
 }
 
 var testAllParams = Parameters{
@@ -116,9 +230,66 @@ var testUserParams = &UserParameters{
 	},
 }
 
+var testActionParams = &ActionParameters{
+	Link:           StringPtr("https://example.com/out"),
+	Download:       StringPtr("https://example.com/file.pdf"),
+	Search:         StringPtr("widgets"),
+	SearchCategory: StringPtr("products"),
+	SearchCount:    Int64Ptr(0),
+	PageViewID:     StringPtr("abc123"),
+	GoalID:         Int64Ptr(2),
+	Revenue:        Float64Ptr(9.99),
+	CustomAction:   BoolPtr(true),
+	Charset:        StringPtr("utf-8"),
+	Dimensions: map[int]string{
+		1:  "free",
+		12: "EU West",
+	},
+}
+
 var testEventParams = &EventTrackingParameters{
 	Category: StringPtr("Event Category"),
 	Action:   StringPtr("Event Action"),
 	Name:     StringPtr("Event Name"),
 	Value:    Float64Ptr(42.42),
 }
+
+func TestValuesAndQueryString(t *testing.T) {
+	params := &Parameters{
+		RecommendedParameters: &RecommendedParameters{
+			ActionName: StringPtr("Help / Feedback"),
+			URL:        StringPtr("https://example.com/search?q=a+b&lang=en#top"),
+			Rand:       Int64Ptr(42),
+		},
+		UserParameters: &UserParameters{
+			CampaignKeyword: StringPtr("Keyword Test"),
+			UserID:          StringPtr("user+tag@example.com"),
+			CurrentHour:     StringPtr("10"),
+			CurrentMinute:   StringPtr("5"),
+			CurrentSecond:   StringPtr("0"),
+		},
+		EventTrackingParameters: &EventTrackingParameters{
+			Category: StringPtr("100% café"),
+			Action:   StringPtr("a=b&c"),
+		},
+	}
+
+	// golden output, with each value escaped exactly once
+	assert.Equal(t, "_rck=Keyword+Test&action_name=Help+%2F+Feedback&apiv=1&e_a=a%3Db%26c&e_c=100%25+caf%C3%A9"+
+		"&h=10&m=5&rand=42&s=0&uid=user%2Btag%40example.com&url=https%3A%2F%2Fexample.com%2Fsearch%3Fq%3Da%2Bb%26lang%3Den%23top",
+		params.QueryString())
+
+	values := params.Values("3")
+	assert.Equal(t, "3", values.Get("idsite"))
+	assert.Equal(t, "1", values.Get("rec"))
+	assert.Equal(t, "Keyword Test", values.Get("_rck"))
+
+	// decoding the query string, as Matomo does, returns the original values
+	decoded, err := url.ParseQuery(params.QueryString())
+	assert.Nil(t, err)
+	assert.Equal(t, "Keyword Test", decoded.Get("_rck"))
+	assert.Equal(t, "https://example.com/search?q=a+b&lang=en#top", decoded.Get("url"))
+	assert.Equal(t, "user+tag@example.com", decoded.Get("uid"))
+	assert.Equal(t, "100% café", decoded.Get("e_c"))
+	assert.Equal(t, "a=b&c", decoded.Get("e_a"))
+}
@@ -0,0 +1,286 @@
+package matomo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxProxyBody limits how much of a tracking request body the proxy will read
+const maxProxyBody = 1 << 20
+
+// scriptFetchTimeout limits how long fetching the tracker JavaScript from Matomo may take
+const scriptFetchTimeout = 30 * time.Second
+
+// ProxyOptions configures the handler returned by NewProxy. Zero values are replaced with sensible defaults.
+type ProxyOptions struct {
+	// ScriptCacheDuration is how long the tracker JavaScript is cached before it is fetched again. Defaults to 1 hour.
+	ScriptCacheDuration time.Duration
+	// TrustForwardedFor uses the X-Forwarded-For and X-Real-IP headers to find the visitor's IP. Only enable this
+	// if the proxy sits behind a load balancer or reverse proxy that sets them, since otherwise they can be forged.
+	TrustForwardedFor bool
+	// SiteIDs are the sites the proxy accepts tracking requests for, in addition to the Client's SiteID. Requests
+	// for any other site are refused, and a request without an idsite is sent to the only allowed site, if there
+	// is just one. If there are no allowed sites, every site is accepted, but the Client's TokenAuth is not added
+	// since it could be used to write into any site it has access to.
+	SiteIDs []string
+}
+
+// proxy forwards tracking requests from the browser to Matomo so they are served from your own domain
+type proxy struct {
+	client  *Client
+	options ProxyOptions
+	// sites are the allowed site ids
+	sites map[string]bool
+
+	mu          sync.Mutex
+	script      []byte
+	scriptType  string
+	scriptFetch time.Time
+	// fetching is closed when the fetch of the script in progress finishes, and nil if there is none
+	fetching chan struct{}
+}
+
+// NewProxy returns an http.Handler that serves Matomo's tracker from your own domain, so ad blockers that block the
+// Matomo domain don't drop your analytics. Requests for a path ending in matomo.js (or piwik.js) are served the
+// tracker JavaScript from the Client's Matomo installation, cached in memory. Requests for a path ending in
+// matomo.php (or piwik.php) are forwarded to Matomo with the visitor's user agent and language added, and, if the
+// Client has a TokenAuth, the visitor's IP. Without a TokenAuth, Matomo will record your server's IP for every
+// visitor. Since the TokenAuth would authenticate whatever the browser sends, the request time and location
+// overrides are removed, and requests are only accepted for the Client's SiteID and ProxyOptions.SiteIDs. Any other
+// path returns a 404, so it can be mounted under any prefix with http.StripPrefix or directly:
+//
+//	mux.Handle("/analytics/", matomo.NewProxy(client, matomo.ProxyOptions{}))
+//
+// Remember to point the JavaScript tracker at the proxy with setTrackerUrl.
+func NewProxy(client *Client, options ProxyOptions) http.Handler {
+	if options.ScriptCacheDuration <= 0 {
+		options.ScriptCacheDuration = time.Hour
+	}
+	p := &proxy{
+		client:  client,
+		options: options,
+		sites:   map[string]bool{},
+	}
+	for _, siteID := range options.SiteIDs {
+		p.sites[siteID] = true
+	}
+	if client.config.SiteID != "" {
+		p.sites[client.config.SiteID] = true
+	}
+	if client.config.TokenAuth != "" && len(p.sites) == 0 {
+		client.logger.Warn("the proxy has no site id to restrict requests to, so the token_auth will not be added")
+	}
+	return p
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "matomo.js") || strings.HasSuffix(r.URL.Path, "piwik.js"):
+		p.serveScript(w, r)
+	case strings.HasSuffix(r.URL.Path, "matomo.php") || strings.HasSuffix(r.URL.Path, "piwik.php"):
+		p.serveTracking(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveScript serves the tracker JavaScript, fetching it from Matomo when the cache is empty or stale
+func (p *proxy) serveScript(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	if (p.script == nil || time.Since(p.scriptFetch) > p.options.ScriptCacheDuration) && p.fetching == nil {
+		p.fetching = make(chan struct{})
+		go p.fetchScript(p.fetching)
+	}
+	script, scriptType, fetching := p.script, p.scriptType, p.fetching
+	p.mu.Unlock()
+
+	// a stale copy is served while it is refreshed, so only the first visitors have to wait for Matomo
+	if script == nil && fetching != nil {
+		select {
+		case <-fetching:
+		case <-r.Context().Done():
+			return
+		}
+		p.mu.Lock()
+		script, scriptType = p.script, p.scriptType
+		p.mu.Unlock()
+	}
+
+	if script == nil {
+		http.Error(w, "the tracker could not be loaded", http.StatusBadGateway)
+		return
+	}
+	if scriptType == "" {
+		scriptType = "application/javascript"
+	}
+	w.Header().Set("Content-Type", scriptType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(p.options.ScriptCacheDuration.Seconds())))
+	w.Write(script)
+}
+
+// fetchScript fetches the tracker JavaScript from Matomo and closes done once it has been stored. It is not tied
+// to any visitor's request, so one that disconnects doesn't fail the fetch for everyone waiting on it.
+func (p *proxy) fetchScript(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), scriptFetchTimeout)
+	defer cancel()
+	resp, err := p.client.http.R().SetContext(ctx).Get(p.client.config.Domain + "/matomo.js")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// if the fetch failed, keep serving the stale copy if there is one
+	switch {
+	case err != nil:
+		p.client.logger.Warn("the proxy could not fetch the tracker JavaScript", "error", err)
+	case resp.StatusCode() != http.StatusOK:
+		p.client.logger.Warn("the proxy could not fetch the tracker JavaScript", "status", resp.StatusCode())
+	default:
+		p.script = resp.Body()
+		p.scriptType = resp.Header().Get("Content-Type")
+		p.scriptFetch = time.Now()
+	}
+	p.fetching = nil
+	close(done)
+}
+
+// serveTracking forwards a tracking request, or a bulk request of them, to Matomo
+func (p *proxy) serveTracking(w http.ResponseWriter, r *http.Request) {
+	body := []byte{}
+	if r.Body != nil {
+		// read one byte past the limit to tell a body that is too large from one that fits exactly
+		read, err := io.ReadAll(io.LimitReader(r.Body, maxProxyBody+1))
+		if err != nil {
+			http.Error(w, "the request could not be read", http.StatusBadRequest)
+			return
+		}
+		if len(read) > maxProxyBody {
+			http.Error(w, "the request is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		body = read
+	}
+
+	request := p.client.http.R().SetContext(r.Context())
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		// the JavaScript tracker sends bulk requests as JSON, regardless of the content type
+		payload := bulkPayload{}
+		if err := json.Unmarshal(trimmed, &payload); err != nil {
+			http.Error(w, "the bulk request is not valid", http.StatusBadRequest)
+			return
+		}
+		for i, encoded := range payload.Requests {
+			values, err := url.ParseQuery(strings.TrimPrefix(encoded, "?"))
+			if err != nil {
+				http.Error(w, "the bulk request is not valid", http.StatusBadRequest)
+				return
+			}
+			if !p.enrich(values, r) {
+				http.Error(w, "the site is not tracked by this proxy", http.StatusForbidden)
+				return
+			}
+			payload.Requests[i] = "?" + values.Encode()
+		}
+		payload.TokenAuth = p.token()
+		request.SetHeader("Content-Type", "application/json").SetBody(payload)
+	} else {
+		values := r.URL.Query()
+		form, parseErr := url.ParseQuery(string(body))
+		if parseErr != nil {
+			http.Error(w, "the request is not valid", http.StatusBadRequest)
+			return
+		}
+		for key, value := range form {
+			values[key] = value
+		}
+		if !p.enrich(values, r) {
+			http.Error(w, "the site is not tracked by this proxy", http.StatusForbidden)
+			return
+		}
+		if token := p.token(); token != "" {
+			values.Set("token_auth", token)
+		}
+		request.SetHeader("Content-Type", "application/x-www-form-urlencoded").SetBody(values.Encode())
+	}
+
+	resp, err := request.Post(p.client.config.Domain + "/matomo.php")
+	if err != nil {
+		http.Error(w, "the request could not be forwarded", http.StatusBadGateway)
+		return
+	}
+	if contentType := resp.Header().Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(resp.StatusCode())
+	w.Write(resp.Body())
+}
+
+// token returns the token_auth to add to forwarded requests, which is only safe when the sites are restricted
+func (p *proxy) token() string {
+	if len(p.sites) == 0 {
+		return ""
+	}
+	return p.client.config.TokenAuth
+}
+
+// enrich adds what Matomo would normally read from the visitor's own request, which it can't see through the
+// proxy, and removes anything the browser can't be trusted to set. It returns false if the site is not allowed.
+func (p *proxy) enrich(values url.Values, r *http.Request) bool {
+	if len(p.sites) > 0 {
+		siteID := values.Get("idsite")
+		if siteID == "" && len(p.sites) == 1 {
+			for only := range p.sites {
+				siteID = only
+			}
+			values.Set("idsite", siteID)
+		}
+		if !p.sites[siteID] {
+			return false
+		}
+	}
+	if values.Get("ua") == "" && r.UserAgent() != "" {
+		values.Set("ua", r.UserAgent())
+	}
+	if values.Get("lang") == "" && r.Header.Get("Accept-Language") != "" {
+		values.Set("lang", r.Header.Get("Accept-Language"))
+	}
+	// the parameters Matomo only accepts with a token_auth would be trusted because of ours, so the browser can't
+	// be allowed to set them
+	values.Del("token_auth")
+	values.Del("cdt")
+	for _, key := range authenticatedKeys {
+		values.Del(key)
+	}
+	if p.token() != "" {
+		if ip := clientIP(r, p.options.TrustForwardedFor); ip != "" {
+			values.Set("cip", ip)
+		}
+	}
+	return true
+}
+
+// clientIP returns the IP of the client that made the request. The forwarding headers are only used if trusted.
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			// the first address is the original client, the rest are the proxies along the way
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
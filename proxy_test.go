@@ -0,0 +1,231 @@
+package matomo
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyTracking(t *testing.T) {
+	received := url.Values{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/matomo.php", r.URL.Path)
+		r.ParseForm()
+		received = r.Form
+		w.Header().Set("Content-Type", "image/gif")
+		w.Write([]byte("GIF89a"))
+	}))
+	defer upstream.Close()
+
+	client := NewClient(upstream.URL, WithSiteID("1"), WithTokenAuth("secret"))
+	proxy := httptest.NewServer(NewProxy(client, ProxyOptions{TrustForwardedFor: true}))
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/analytics/matomo.php?idsite=1&rec=1&action_name=Home%20Page&cip=1.1.1.1", nil)
+	req.Header.Set("User-Agent", "TestBrowser/1.0")
+	req.Header.Set("Accept-Language", "eo")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/gif", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "GIF89a", string(body))
+
+	assert.Equal(t, "1", received.Get("idsite"))
+	assert.Equal(t, "Home Page", received.Get("action_name"))
+	assert.Equal(t, "TestBrowser/1.0", received.Get("ua"))
+	assert.Equal(t, "eo", received.Get("lang"))
+	assert.Equal(t, "203.0.113.7", received.Get("cip"))
+	assert.Equal(t, "secret", received.Get("token_auth"))
+
+	// other paths are not proxied
+	resp, err = http.Get(proxy.URL + "/analytics/index.php")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestProxyBulkTracking(t *testing.T) {
+	received := bulkPayload{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	// without a token, the visitor IP can't be forwarded
+	proxy := httptest.NewServer(NewProxy(NewClient(upstream.URL), ProxyOptions{}))
+	defer proxy.Close()
+
+	body := `{"requests":["?idsite=1&rec=1&action_name=one","?idsite=1&rec=1&action_name=two&cip=1.1.1.1"]}`
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/matomo.php", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	req.Header.Set("User-Agent", "TestBrowser/1.0")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	assert.Equal(t, 2, len(received.Requests))
+	assert.Empty(t, received.TokenAuth)
+	for _, request := range received.Requests {
+		values, err := url.ParseQuery(strings.TrimPrefix(request, "?"))
+		assert.Nil(t, err)
+		assert.Equal(t, "TestBrowser/1.0", values.Get("ua"))
+		assert.Empty(t, values.Get("cip"))
+	}
+
+	// a body over the limit is refused rather than cut short
+	received = bulkPayload{}
+	body = `{"requests":["?idsite=1&rec=1&action_name=` + strings.Repeat("a", maxProxyBody) + `"]}`
+	resp, err = http.Post(proxy.URL+"/matomo.php", "application/json", strings.NewReader(body))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Empty(t, received.Requests)
+}
+
+func TestProxyAuthenticatedParameters(t *testing.T) {
+	received := make(chan url.Values, 10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") == "application/json" {
+			payload := bulkPayload{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			for _, request := range payload.Requests {
+				values, _ := url.ParseQuery(strings.TrimPrefix(request, "?"))
+				values.Set("token_auth", payload.TokenAuth)
+				received <- values
+			}
+		} else {
+			r.ParseForm()
+			received <- r.PostForm
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	client := NewClient(upstream.URL, WithSiteID("1"), WithTokenAuth("secret"))
+	proxy := httptest.NewServer(NewProxy(client, ProxyOptions{SiteIDs: []string{"2"}}))
+	defer proxy.Close()
+	send := func(query, body string) int {
+		req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/matomo.php?"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	forged := "idsite=1&rec=1&action_name=Home&cdt=1000000000&country=fr&region=01&city=Paris&lat=1&long=2&token_auth=stolen"
+
+	// forged values in the query string, the form body and the bulk body are all removed
+	assert.Equal(t, http.StatusNoContent, send(forged, ""))
+	assert.Equal(t, http.StatusNoContent, send("", forged))
+	assert.Equal(t, http.StatusNoContent, send("", `{"requests":["?`+forged+`","?`+strings.Replace(forged, "idsite=1", "idsite=2", 1)+`"]}`))
+	for i := 0; i < 4; i++ {
+		values := <-received
+		assert.Equal(t, "Home", values.Get("action_name"))
+		assert.Equal(t, "secret", values.Get("token_auth"))
+		assert.NotEmpty(t, values.Get("cip"))
+		for _, key := range []string{"cdt", "country", "region", "city", "lat", "long"} {
+			assert.Empty(t, values.Get(key), key)
+		}
+	}
+
+	// only the allowed sites can be tracked
+	assert.Equal(t, http.StatusForbidden, send("idsite=3&rec=1", ""))
+	assert.Equal(t, http.StatusForbidden, send("", `{"requests":["?idsite=1&rec=1","?idsite=3&rec=1"]}`))
+	assert.Equal(t, http.StatusForbidden, send("rec=1", ""))
+
+	// a single allowed site is used when the request has none
+	proxy.Config.Handler = NewProxy(client, ProxyOptions{})
+	assert.Equal(t, http.StatusNoContent, send("rec=1&action_name=Home", ""))
+	assert.Equal(t, "1", (<-received).Get("idsite"))
+
+	// without any allowed sites, the token is not added
+	proxy.Config.Handler = NewProxy(NewClient(upstream.URL, WithTokenAuth("secret"), WithLogger(NewNopLogger())), ProxyOptions{})
+	assert.Equal(t, http.StatusNoContent, send("idsite=9&rec=1&action_name=Home&cdt=1000000000", ""))
+	values := <-received
+	assert.Equal(t, "9", values.Get("idsite"))
+	assert.Empty(t, values.Get("token_auth"))
+	assert.Empty(t, values.Get("cdt"))
+}
+
+func TestProxyScriptCache(t *testing.T) {
+	fetches := int32(0)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/matomo.js", r.URL.Path)
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Write([]byte("var Matomo = {};"))
+	}))
+	defer upstream.Close()
+
+	proxy := httptest.NewServer(NewProxy(NewClient(upstream.URL), ProxyOptions{}))
+	defer proxy.Close()
+
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(proxy.URL + "/js/matomo.js")
+		assert.Nil(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "var Matomo = {};", string(body))
+		assert.Equal(t, "application/javascript", resp.Header.Get("Content-Type"))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestProxyScriptFetchOutlivesVisitor(t *testing.T) {
+	fetches := int32(0)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		w.Write([]byte("var Matomo = {};"))
+	}))
+	defer upstream.Close()
+	handler := NewProxy(NewClient(upstream.URL), ProxyOptions{})
+
+	// the first visitor gives up while the script is being fetched
+	ctx, cancel := context.WithCancel(context.Background())
+	first := httptest.NewRequest(http.MethodGet, "/matomo.js", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), first)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&fetches) == 1 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	// the next visitor still gets the script from that same fetch
+	close(release)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/matomo.js", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "var Matomo = {};", recorder.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	assert.Equal(t, "192.0.2.1", clientIP(r, false))
+	assert.Equal(t, "203.0.113.7", clientIP(r, true))
+
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Set("X-Real-IP", "198.51.100.3")
+	assert.Equal(t, "198.51.100.3", clientIP(r, true))
+}
@@ -0,0 +1,129 @@
+package matomo
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// RetryPolicy controls how a Client retries requests that fail for transient reasons. Retries reuse the already
+// encoded request, so the rand value and local time are unchanged, and the time of the first attempt is sent as
+// the request time so Matomo records the event once and at the right moment.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Values below 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. Each following retry waits twice as long.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts.
+	MaxBackoff time.Duration
+	// Jitter is the fraction (0 to 1) of each wait that is randomized so many clients do not retry in lockstep.
+	Jitter float64
+	// RetryableStatusCodes are the HTTP status codes that should be retried.
+	RetryableStatusCodes []int
+	// IsRetryable decides whether a transport error should be retried. If nil, every transport error other than
+	// a cancelled or expired context is retried.
+	IsRetryable func(err error) bool
+}
+
+// DefaultRetryPolicy returns a policy that makes up to 3 attempts with exponential backoff starting at 200ms,
+// retrying transport errors, timeouts, rate limiting and server errors.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Jitter:         0.2,
+		RetryableStatusCodes: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// WithRetryPolicy sets the policy the Client uses to retry failed requests. By default, requests are not retried.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = &policy
+	}
+}
+
+// enabled returns true if the policy allows more than one attempt
+func (p *RetryPolicy) enabled() bool {
+	return p != nil && p.MaxAttempts > 1
+}
+
+// backoff returns how long to wait after the provided attempt (starting at 1) before trying again
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait -= time.Duration(p.Jitter * rand.Float64() * float64(wait))
+	}
+	return wait
+}
+
+func (p *RetryPolicy) retryableStatus(code int) bool {
+	for _, retryable := range p.RetryableStatusCodes {
+		if code == retryable {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if p.IsRetryable != nil {
+		return p.IsRetryable(err)
+	}
+	return true
+}
+
+// execute runs the request, retrying according to the Client's policy. Errors caused by ctx ending are wrapped
+// with contextError, and transport timeouts match ErrTimeout. The response of the last attempt is returned for the
+// caller to check the status code.
+func (c *Client) execute(ctx context.Context, request func() (*resty.Response, error)) (*resty.Response, error) {
+	attempt := 1
+	for {
+		resp, err := request()
+		if err != nil && ctx.Err() != nil {
+			return nil, contextError(ctx.Err())
+		}
+		if !c.retry.enabled() || attempt >= c.retry.MaxAttempts {
+			return resp, transportError(err)
+		}
+		if err != nil && !c.retry.retryableError(err) {
+			return nil, transportError(err)
+		}
+		if err == nil && !c.retry.retryableStatus(resp.StatusCode()) {
+			return resp, nil
+		}
+
+		backoff := c.retry.backoff(attempt)
+		c.logger.Debug("retrying tracking request", "attempt", attempt, "backoff", backoff)
+		c.observer.RequestRetried(attempt)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, contextError(ctx.Err())
+		}
+		attempt++
+	}
+}
@@ -0,0 +1,109 @@
+package matomo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryTransientFailures(t *testing.T) {
+	attempts := []url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts = append(attempts, r.URL.Query())
+		if len(attempts) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := NewClient(server.URL, WithSiteID("1"), WithRetryPolicy(policy))
+	err := client.Send(&Parameters{
+		RecommendedParameters: &RecommendedParameters{},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(attempts))
+
+	// every attempt must be the same request so Matomo does not double count
+	assert.NotEmpty(t, attempts[0].Get("rand"))
+	assert.NotEmpty(t, attempts[0].Get("cdt"))
+	for _, attempt := range attempts[1:] {
+		assert.Equal(t, attempts[0].Get("rand"), attempt.Get("rand"))
+		assert.Equal(t, attempts[0].Get("cdt"), attempt.Get("cdt"))
+		assert.Equal(t, attempts[0].Get("s"), attempt.Get("s"))
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	attempts := 0
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := NewClient(server.URL, WithSiteID("1"), WithRetryPolicy(policy))
+	err := client.Send(&Parameters{})
+	assert.NotNil(t, err)
+	assert.Equal(t, policy.MaxAttempts, attempts)
+
+	// a bad request will never succeed, so it should not be retried
+	attempts = 0
+	status = http.StatusBadRequest
+	err = client.Send(&Parameters{})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+
+	// without a policy, nothing is retried
+	attempts = 0
+	status = http.StatusServiceUnavailable
+	err = NewClient(server.URL, WithSiteID("1")).Send(&Parameters{})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryContextCancelledDuringBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Hour
+	policy.MaxBackoff = time.Hour
+	client := NewClient(server.URL, WithSiteID("1"), WithRetryPolicy(policy))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.SendContext(ctx, &Parameters{})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     300 * time.Millisecond,
+	}
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 300*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 300*time.Millisecond, policy.backoff(10))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		wait := policy.backoff(1)
+		assert.True(t, wait >= 50*time.Millisecond && wait <= 100*time.Millisecond)
+	}
+}
@@ -0,0 +1,372 @@
+package matomo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSpooled is wrapped by the error returned from a send that failed for a transient reason but was saved to the
+// Client's spool. The event will be delivered when the spool is replayed, so callers can treat it as sent.
+var ErrSpooled = errors.New("the request could not be delivered and was saved to the spool")
+
+// spoolExtension is the file extension of spooled requests; anything else in the directory is ignored
+const spoolExtension = ".req"
+
+// SpoolOptions caps how much a Spool keeps on disk. When a cap is exceeded, the oldest entries are dropped first.
+// Zero values mean no limit.
+type SpoolOptions struct {
+	// MaxEntries is the most requests kept in the spool.
+	MaxEntries int
+	// MaxBytes is the most disk space the spooled requests may use.
+	MaxBytes int64
+	// MaxAge drops requests older than this. Keep in mind Matomo requires token_auth to record requests
+	// older than 24 hours.
+	MaxAge time.Duration
+	// BatchSize is the number of requests sent in each bulk request while replaying. Defaults to 50.
+	BatchSize int
+	// Logger is warned when requests are dropped to keep the spool within its caps. Defaults to the Logger of the
+	// Client given the spool, or NewStdLogger(nil, false) until then.
+	Logger Logger
+	// Observer is told when requests are dropped to keep the spool within its caps. Defaults to the Observer of
+	// the Client given the spool.
+	Observer Observer
+}
+
+// Spool persists encoded tracking requests to a local directory so they are not lost while Matomo is
+// unreachable. Each request is stored in its own file, named so that a directory listing returns them in the
+// order they were added.
+type Spool struct {
+	// pending is the number of spooled requests, kept in memory so sends can check it without listing the
+	// directory. It is first in the struct so it is aligned for atomic access on 32 bit platforms.
+	pending int64
+
+	dir     string
+	options SpoolOptions
+
+	mu       sync.Mutex
+	seq      uint64
+	logger   Logger
+	observer Observer
+
+	// replayMu is held while the spool is replayed, so two replays never send the same requests
+	replayMu sync.Mutex
+	// replaying is set while a replay is running in the background
+	replaying int32
+}
+
+// spoolEntry is a single spooled request file
+type spoolEntry struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// NewSpool creates a Spool that stores requests in dir, creating the directory if needed
+func NewSpool(dir string, options SpoolOptions) (*Spool, error) {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 50
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	spool := &Spool{
+		dir:      dir,
+		options:  options,
+		logger:   options.Logger,
+		observer: options.Observer,
+	}
+	if spool.logger == nil {
+		spool.logger = NewStdLogger(nil, false)
+	}
+	if spool.observer == nil {
+		spool.observer = NopObserver{}
+	}
+	// requests spooled by a previous run are replayed too
+	entries, err := spool.entries()
+	if err != nil {
+		return nil, err
+	}
+	spool.pending = int64(len(entries))
+	return spool, nil
+}
+
+// WithSpool makes the Client save requests that fail for a transient reason to the spool. Spooled requests are
+// replayed in the background after the next successful send, or when ReplaySpool is called. Unless they were
+// set in its SpoolOptions, the spool reports to the Client's Logger and Observer.
+func WithSpool(spool *Spool) Option {
+	return func(c *Client) {
+		c.spool = spool
+	}
+}
+
+// Add stores an encoded request in the spool, then drops the oldest entries if the spool is over its caps
+func (s *Spool) Add(request string) error {
+	return s.addAll([]string{request})
+}
+
+// addAll stores the requests in the spool, either all of them or, if any could not be written, none of them. The
+// lock is held throughout, so a replay never sees part of the batch.
+func (s *Spool) addAll(requests []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// write to temporary files first so a crash never leaves a partial request behind
+	names := make([]string, 0, len(requests))
+	for _, request := range requests {
+		s.seq++
+		name := fmt.Sprintf("%020d-%010d%s", time.Now().UnixNano(), s.seq, spoolExtension)
+		tmp := filepath.Join(s.dir, name+".tmp")
+		if err := os.WriteFile(tmp, []byte(request), 0600); err != nil {
+			os.Remove(tmp)
+			s.discard(names, ".tmp")
+			return err
+		}
+		names = append(names, name)
+	}
+	for i, name := range names {
+		if err := os.Rename(filepath.Join(s.dir, name+".tmp"), filepath.Join(s.dir, name)); err != nil {
+			s.discard(names[:i], "")
+			s.discard(names[i:], ".tmp")
+			return err
+		}
+	}
+	atomic.AddInt64(&s.pending, int64(len(names)))
+	if _, err := s.trim(); err != nil {
+		// the requests are stored, they just may not be within the caps until the next trim
+		s.logger.Warn("the spool could not be trimmed to its caps", "error", err)
+	}
+	return nil
+}
+
+// discard removes files written by addAll that are being rolled back. The caller must hold the lock.
+func (s *Spool) discard(names []string, suffix string) {
+	for _, name := range names {
+		os.Remove(filepath.Join(s.dir, name+suffix))
+	}
+}
+
+// inherit makes the spool report to the Client's logger and observer, unless its options set their own
+func (s *Spool) inherit(logger Logger, observer Observer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.options.Logger == nil {
+		s.logger = logger
+	}
+	if s.options.Observer == nil {
+		s.observer = observer
+	}
+}
+
+// Len returns the number of requests in the spool
+func (s *Spool) Len() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.entries()
+	return len(entries), err
+}
+
+// entries lists the spooled requests, oldest first. The caller must hold the lock.
+func (s *Spool) entries() ([]spoolEntry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := []spoolEntry{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), spoolExtension) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			// the file was removed since the directory was read
+			continue
+		}
+		entries = append(entries, spoolEntry{name: file.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries, nil
+}
+
+// trim removes the oldest entries until the spool is within its caps, returning how many were dropped. The
+// caller must hold the lock.
+func (s *Spool) trim() (int, error) {
+	entries, err := s.entries()
+	if err != nil {
+		return 0, err
+	}
+	total := int64(0)
+	for _, entry := range entries {
+		total += entry.size
+	}
+	dropped := 0
+	for _, entry := range entries {
+		tooMany := s.options.MaxEntries > 0 && len(entries)-dropped > s.options.MaxEntries
+		tooBig := s.options.MaxBytes > 0 && total > s.options.MaxBytes
+		tooOld := s.options.MaxAge > 0 && time.Since(entry.modTime) > s.options.MaxAge
+		if !tooMany && !tooBig && !tooOld {
+			break
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+			return dropped, err
+		}
+		total -= entry.size
+		dropped++
+	}
+	atomic.StoreInt64(&s.pending, int64(len(entries)-dropped))
+	if dropped > 0 {
+		s.logger.Warn("dropped the oldest spooled requests to stay within the spool caps", "dropped", dropped)
+		s.observer.EventsDropped(dropped, DropSpoolFull)
+	}
+	return dropped, nil
+}
+
+// next returns up to BatchSize of the oldest entries along with their contents
+func (s *Spool) next() ([]string, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.trim(); err != nil {
+		return nil, nil, err
+	}
+	entries, err := s.entries()
+	if err != nil {
+		return nil, nil, err
+	}
+	names := []string{}
+	requests := []string{}
+	for _, entry := range entries {
+		if len(names) >= s.options.BatchSize {
+			break
+		}
+		contents, err := os.ReadFile(filepath.Join(s.dir, entry.name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+		names = append(names, entry.name)
+		requests = append(requests, string(contents))
+	}
+	return names, requests, nil
+}
+
+// remove deletes entries that have been delivered
+func (s *Spool) remove(names []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		atomic.AddInt64(&s.pending, -1)
+	}
+	return nil
+}
+
+// hasPending returns true if there may be requests in the spool, without touching the disk
+func (s *Spool) hasPending() bool {
+	return atomic.LoadInt64(&s.pending) > 0
+}
+
+// ReplaySpool sends everything in the Client's spool to Matomo in the order it was spooled. It stops at the first
+// transient failure or when ctx is done, leaving the remaining requests in the spool. A batch Matomo rejects, with
+// a client error status or a failed bulk response, is removed and reported to the Observer as dropped, since it
+// would never succeed and would otherwise block everything spooled after it. Only one replay of a Spool runs at a
+// time, even when it is shared by several Clients, so a call waits for any replay already running.
+func (c *Client) ReplaySpool(ctx context.Context) error {
+	if c.spool == nil {
+		return nil
+	}
+	c.spool.replayMu.Lock()
+	defer c.spool.replayMu.Unlock()
+	for {
+		names, requests, err := c.spool.next()
+		if err != nil {
+			return err
+		}
+		if len(requests) == 0 {
+			return nil
+		}
+		_, err = c.postBulk(ctx, requests)
+		// a bulk error that is not a failure of the whole request only means some requests were invalid
+		var bulkErr *BulkError
+		if err != nil && !(errors.As(err, &bulkErr) && bulkErr.Result.Status != "error") {
+			if transient(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			c.logger.Warn("dropped spooled requests that Matomo rejected", "requests", len(requests), "error", err)
+			c.observer.EventsDropped(len(requests), DropSpoolRejected)
+		}
+		if err := c.spool.remove(names); err != nil {
+			return err
+		}
+	}
+}
+
+// replayInBackground starts replaying the spool if there is anything in it and a replay is not already running
+func (c *Client) replayInBackground() {
+	if c.spool == nil || !c.spool.hasPending() || !atomic.CompareAndSwapInt32(&c.spool.replaying, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.spool.replaying, 0)
+		c.ReplaySpool(context.Background())
+	}()
+}
+
+// transient returns true if the error is a failure that may succeed later, such as a transport error or a
+// server error. Cancelled requests and requests Matomo rejected are not transient.
+func transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var bulkErr *BulkError
+	if errors.As(err, &bulkErr) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	return true
+}
+
+// spoolError is returned when requests failed to send and could not be spooled either. They have already been
+// reported to the Observer as dropped.
+type spoolError struct {
+	cause error
+	err   error
+}
+
+func (e *spoolError) Error() string {
+	return fmt.Sprintf("%v, and it could not be spooled: %v", e.cause, e.err)
+}
+
+func (e *spoolError) Unwrap() error {
+	return e.cause
+}
+
+// spoolRequests saves the requests to the spool after a transient failure, returning the error to give the caller
+func (c *Client) spoolRequests(requests []string, cause error) error {
+	// the requests are spooled all together or not at all, so the caller is never left with part of them spooled
+	if err := c.spool.addAll(requests); err != nil {
+		c.logger.Warn("dropped tracking requests that could not be spooled", "requests", len(requests), "error", err)
+		c.observer.EventsDropped(len(requests), DropSpoolFailed)
+		return &spoolError{cause: cause, err: err}
+	}
+	c.logger.Info("spooled tracking requests after a transient failure", "requests", len(requests), "error", cause)
+	return fmt.Errorf("%w: %v", ErrSpooled, cause)
+}
@@ -0,0 +1,194 @@
+package matomo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpoolCaps(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), SpoolOptions{MaxEntries: 3})
+	assert.Nil(t, err)
+	for _, request := range []string{"?a=1", "?a=2", "?a=3", "?a=4", "?a=5"} {
+		assert.Nil(t, spool.Add(request))
+	}
+	count, err := spool.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	// the oldest entries should have been dropped, and the rest kept in order
+	_, requests, err := spool.next()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"?a=3", "?a=4", "?a=5"}, requests)
+
+	// a new spool on the same directory knows about the requests already in it
+	dir := t.TempDir()
+	spool, err = NewSpool(dir, SpoolOptions{})
+	assert.Nil(t, err)
+	assert.False(t, spool.hasPending())
+	assert.Nil(t, spool.Add("?a=1"))
+	spool, err = NewSpool(dir, SpoolOptions{})
+	assert.Nil(t, err)
+	assert.True(t, spool.hasPending())
+	names, _, err := spool.next()
+	assert.Nil(t, err)
+	assert.Nil(t, spool.remove(names))
+	assert.False(t, spool.hasPending())
+
+	spool, err = NewSpool(t.TempDir(), SpoolOptions{MaxBytes: 10})
+	assert.Nil(t, err)
+	for _, request := range []string{"?abc=1", "?abc=2", "?abc=3"} {
+		assert.Nil(t, spool.Add(request))
+	}
+	_, requests, err = spool.next()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"?abc=3"}, requests)
+}
+
+func TestTransient(t *testing.T) {
+	assert.True(t, transient(errors.New("connection refused")))
+	assert.True(t, transient(&StatusError{Code: http.StatusBadGateway}))
+	assert.True(t, transient(&StatusError{Code: http.StatusInternalServerError}))
+	assert.True(t, transient(&StatusError{Code: http.StatusRequestTimeout}))
+	assert.True(t, transient(&StatusError{Code: http.StatusTooManyRequests}))
+	assert.False(t, transient(&StatusError{Code: http.StatusBadRequest}))
+	assert.False(t, transient(contextError(context.Canceled)))
+	assert.False(t, transient(&BulkError{Result: &BulkResult{Status: "error"}}))
+	assert.False(t, transient(nil))
+}
+
+func TestClientSpoolsAndReplays(t *testing.T) {
+	down := int32(1)
+	recorder := &bulkRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == http.MethodPost {
+			recorder.ServeHTTP(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	spool, err := NewSpool(t.TempDir(), SpoolOptions{})
+	assert.Nil(t, err)
+	client := NewClient(server.URL, WithSiteID("1"), WithSpool(spool))
+
+	// while the server is down, events end up in the spool
+	for _, name := range []string{"first", "second"} {
+		err = client.Send(&Parameters{
+			RecommendedParameters: &RecommendedParameters{
+				ActionName: StringPtr(name),
+			},
+		})
+		assert.True(t, errors.Is(err, ErrSpooled))
+	}
+	count, err := spool.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	// once the server is back, the next successful send replays the spool in order
+	atomic.StoreInt32(&down, 0)
+	client = NewClient(server.URL, WithSiteID("1"), WithSpool(spool))
+	assert.Nil(t, client.Send(&Parameters{}))
+	assert.Eventually(t, func() bool {
+		count, _ := spool.Len()
+		return count == 0
+	}, time.Second, 10*time.Millisecond)
+
+	sent, _ := recorder.count()
+	assert.Equal(t, 2, sent)
+	first, _ := url.ParseQuery(strings.TrimPrefix(recorder.requests[0], "?"))
+	assert.Equal(t, "first", first.Get("action_name"))
+	assert.NotEmpty(t, first.Get("cdt"))
+	second, _ := url.ParseQuery(strings.TrimPrefix(recorder.requests[1], "?"))
+	assert.Equal(t, "second", second.Get("action_name"))
+}
+
+func TestReplaySpoolDropsRejectedRequests(t *testing.T) {
+	down := int32(1)
+	recorder := &bulkRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		switch {
+		case atomic.LoadInt32(&down) == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case bytes.Contains(body, []byte("invalid")):
+			w.WriteHeader(http.StatusBadRequest)
+		case bytes.Contains(body, []byte("failed")):
+			w.Write([]byte(`{"status":"error","message":"failed"}`))
+		default:
+			recorder.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	spool, err := NewSpool(t.TempDir(), SpoolOptions{BatchSize: 1})
+	assert.Nil(t, err)
+	for _, request := range []string{"?a=invalid", "?a=failed", "?a=valid"} {
+		assert.Nil(t, spool.Add(request))
+	}
+	observer := &recordingObserver{}
+	client := NewClient(server.URL, WithSiteID("1"), WithSpool(spool), WithObserver(observer), WithLogger(NewNopLogger()))
+
+	// transient failures leave everything in the spool
+	assert.NotNil(t, client.ReplaySpool(context.Background()))
+	count, _ := spool.Len()
+	assert.Equal(t, 3, count)
+
+	// rejected requests are dropped rather than blocking the ones behind them
+	atomic.StoreInt32(&down, 0)
+	assert.Nil(t, client.ReplaySpool(context.Background()))
+	count, _ = spool.Len()
+	assert.Equal(t, 0, count)
+	assert.Equal(t, []string{"?a=valid"}, recorder.requests)
+	assert.Equal(t, 2, observer.dropped[DropSpoolRejected])
+}
+
+func TestConcurrentReplaysSendOnce(t *testing.T) {
+	recorder := &bulkRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		recorder.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	spool, err := NewSpool(t.TempDir(), SpoolOptions{BatchSize: 1})
+	assert.Nil(t, err)
+	for _, request := range []string{"?a=1", "?a=2", "?a=3", "?a=4", "?a=5"} {
+		assert.Nil(t, spool.Add(request))
+	}
+
+	// replays from clients sharing the spool, in the background and called directly, never overlap
+	first := NewClient(server.URL, WithSiteID("1"), WithSpool(spool))
+	second := NewClient(server.URL, WithSiteID("1"), WithSpool(spool))
+	first.replayInBackground()
+	var wg sync.WaitGroup
+	for _, client := range []*Client{first, second, first, second} {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			assert.Nil(t, client.ReplaySpool(context.Background()))
+		}(client)
+	}
+	wg.Wait()
+
+	sent, _ := recorder.count()
+	assert.Equal(t, 5, sent)
+	assert.Equal(t, []string{"?a=1", "?a=2", "?a=3", "?a=4", "?a=5"}, recorder.requests)
+}
@@ -0,0 +1,245 @@
+package matomo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Track when the Tracker's queue has no room for another event
+var ErrQueueFull = errors.New("the tracker queue is full")
+
+// ErrTrackerClosed is returned by Track once the Tracker has been closed
+var ErrTrackerClosed = errors.New("the tracker is closed")
+
+// TrackerOptions configures a Tracker. Zero values are replaced with sensible defaults.
+type TrackerOptions struct {
+	// QueueSize is the maximum number of events waiting to be sent. Defaults to 1000.
+	QueueSize int
+	// BatchSize is the number of events that triggers a flush and the most sent in one bulk request. Defaults to 50.
+	BatchSize int
+	// FlushInterval is how often queued events are sent even if the batch is not full. Defaults to 5 seconds.
+	FlushInterval time.Duration
+	// FlushTimeout limits how long a background flush may take. Defaults to 30 seconds.
+	FlushTimeout time.Duration
+	// OnError is called with the error from any background flush that fails. It may be nil.
+	OnError func(err error)
+}
+
+// Tracker queues events and sends them to Matomo in the background using the bulk tracking API, so the caller
+// does not wait on a round trip to Matomo. Create one with NewTracker and call Close on shutdown so queued
+// events are not lost.
+type Tracker struct {
+	client  *Client
+	options TrackerOptions
+
+	queue   chan string
+	flushes chan flushRequest
+	stop    chan flushRequest
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+	// queueMu serializes adding to the queue, so an event's visit is only recorded once it is sure to be queued
+	queueMu sync.Mutex
+}
+
+// flushRequest asks the background loop to send everything it has and report the result
+type flushRequest struct {
+	ctx   context.Context
+	reply chan error
+}
+
+// NewTracker creates a Tracker that sends through client and starts its background loop
+func NewTracker(client *Client, options TrackerOptions) *Tracker {
+	if options.QueueSize <= 0 {
+		options.QueueSize = 1000
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 50
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = 5 * time.Second
+	}
+	if options.FlushTimeout <= 0 {
+		options.FlushTimeout = 30 * time.Second
+	}
+	t := &Tracker{
+		client:  client,
+		options: options,
+		queue:   make(chan string, options.QueueSize),
+		flushes: make(chan flushRequest),
+		stop:    make(chan flushRequest, 1),
+		done:    make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Track queues the parameters for the site id the client was configured with. It never blocks; if the queue is
+// full the event is dropped and ErrQueueFull is returned.
+func (t *Tracker) Track(params *Parameters) error {
+	return t.TrackToSite(t.client.config.SiteID, params)
+}
+
+// TrackToSite queues the parameters for the provided site. The parameters are encoded immediately, and the time
+// of the call is sent as the request time so the event is recorded when it happened rather than when it was sent.
+func (t *Tracker) TrackToSite(siteID string, params *Parameters) error {
+	if siteID == "" {
+		return ErrMissingSiteID
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return ErrTrackerClosed
+	}
+	data, err := t.client.encodeRequest(siteID, params)
+	if err != nil {
+		return err
+	}
+	if _, ok := data["cdt"]; !ok {
+		data["cdt"] = fmt.Sprintf("%d", time.Now().Unix())
+	}
+
+	// callers take turns queueing, so a free slot found here is still free once the visit has been recorded
+	t.queueMu.Lock()
+	defer t.queueMu.Unlock()
+	if len(t.queue) == cap(t.queue) {
+		t.client.logger.Warn("dropped an event because the tracker queue is full", "queue_size", t.options.QueueSize)
+		t.client.observer.EventsDropped(1, DropQueueFull)
+		return ErrQueueFull
+	}
+	t.client.trackVisit(siteID, data)
+	t.queue <- queryString(data)
+	t.client.observer.QueueDepth(len(t.queue))
+	return nil
+}
+
+// Flush sends every queued event and waits for Matomo to respond or for ctx to end
+func (t *Tracker) Flush(ctx context.Context) error {
+	req := flushRequest{ctx: ctx, reply: make(chan error, 1)}
+	select {
+	case t.flushes <- req:
+	case <-t.done:
+		return ErrTrackerClosed
+	case <-ctx.Done():
+		return contextError(ctx.Err())
+	}
+	select {
+	case err := <-req.reply:
+		return err
+	case <-ctx.Done():
+		return contextError(ctx.Err())
+	}
+}
+
+// Close stops accepting new events, sends everything still queued and stops the background loop. If ctx ends
+// before the queue is drained, the remaining events are abandoned and the context error is returned.
+func (t *Tracker) Close(ctx context.Context) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return ErrTrackerClosed
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	req := flushRequest{ctx: ctx, reply: make(chan error, 1)}
+	t.stop <- req
+	select {
+	case err := <-req.reply:
+		return err
+	case <-ctx.Done():
+		return contextError(ctx.Err())
+	}
+}
+
+// Len returns the number of events waiting in the queue
+func (t *Tracker) Len() int {
+	return len(t.queue)
+}
+
+func (t *Tracker) run() {
+	ticker := time.NewTicker(t.options.FlushInterval)
+	defer ticker.Stop()
+	defer close(t.done)
+
+	batch := []string{}
+	for {
+		select {
+		case request := <-t.queue:
+			batch = append(batch, request)
+			if len(batch) >= t.options.BatchSize {
+				t.background(batch)
+				batch = []string{}
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				t.background(batch)
+				batch = []string{}
+			}
+		case req := <-t.flushes:
+			req.reply <- t.send(req.ctx, t.drain(batch))
+			batch = []string{}
+		case req := <-t.stop:
+			req.reply <- t.send(req.ctx, t.drain(batch))
+			return
+		}
+	}
+}
+
+// drain moves everything currently in the queue onto the batch
+func (t *Tracker) drain(batch []string) []string {
+	for {
+		select {
+		case request := <-t.queue:
+			batch = append(batch, request)
+		default:
+			return batch
+		}
+	}
+}
+
+// background sends a batch from the loop, reporting any error to OnError
+func (t *Tracker) background(batch []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.options.FlushTimeout)
+	defer cancel()
+	err := t.send(ctx, batch)
+	if err != nil && t.options.OnError != nil {
+		t.options.OnError(err)
+	}
+}
+
+// send posts the requests in chunks of at most BatchSize, returning the first error. Every event that is not
+// delivered or spooled is reported as dropped here, whether the send was in the background or from Flush or Close.
+func (t *Tracker) send(ctx context.Context, requests []string) error {
+	t.client.observer.QueueDepth(len(t.queue))
+	var firstErr error
+	for len(requests) > 0 {
+		size := t.options.BatchSize
+		if size > len(requests) {
+			size = len(requests)
+		}
+		if _, err := t.client.sendBulkRequests(ctx, requests[:size]); err != nil {
+			t.dropped(size, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		requests = requests[size:]
+	}
+	return firstErr
+}
+
+// dropped reports the events of a failed bulk request as dropped, unless they were spooled or already reported
+// as dropped by the spool
+func (t *Tracker) dropped(events int, err error) {
+	var spoolErr *spoolError
+	if errors.Is(err, ErrSpooled) || errors.As(err, &spoolErr) {
+		return
+	}
+	t.client.logger.Error("the tracker could not deliver a batch of events", "events", events, "error", err)
+	t.client.observer.EventsDropped(droppedEvents(events, err), DropDeliveryFailed)
+}
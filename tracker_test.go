@@ -0,0 +1,125 @@
+package matomo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bulkRecorder is a test server that records every request sent to the bulk endpoint
+type bulkRecorder struct {
+	mu       sync.Mutex
+	requests []string
+	batches  int
+}
+
+func (b *bulkRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload := bulkPayload{}
+	json.NewDecoder(r.Body).Decode(&payload)
+	b.mu.Lock()
+	b.requests = append(b.requests, payload.Requests...)
+	b.batches++
+	b.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BulkResult{Status: "success", Tracked: len(payload.Requests)})
+}
+
+func (b *bulkRecorder) count() (int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.requests), b.batches
+}
+
+func TestTrackerBatchSize(t *testing.T) {
+	recorder := &bulkRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	tracker := NewTracker(NewClient(server.URL, WithSiteID("1")), TrackerOptions{
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.Nil(t, tracker.Track(&Parameters{}))
+
+	// the full batch should be sent without waiting for the interval
+	assert.Eventually(t, func() bool {
+		sent, _ := recorder.count()
+		return sent == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, tracker.Close(context.Background()))
+}
+
+func TestTrackerFlushAndClose(t *testing.T) {
+	recorder := &bulkRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	tracker := NewTracker(NewClient(server.URL, WithSiteID("1")), TrackerOptions{
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+	})
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, tracker.Track(&Parameters{}))
+	}
+	assert.Nil(t, tracker.Flush(context.Background()))
+	sent, batches := recorder.count()
+	assert.Equal(t, 3, sent)
+	assert.Equal(t, 1, batches)
+
+	// the request time should be recorded when the event was tracked
+	values, err := url.ParseQuery(strings.TrimPrefix(recorder.requests[0], "?"))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, values.Get("cdt"))
+
+	assert.Nil(t, tracker.TrackToSite("2", &Parameters{}))
+	assert.Nil(t, tracker.Close(context.Background()))
+	sent, _ = recorder.count()
+	assert.Equal(t, 4, sent)
+
+	assert.Equal(t, ErrTrackerClosed, tracker.Track(&Parameters{}))
+	assert.Equal(t, ErrTrackerClosed, tracker.Close(context.Background()))
+	assert.Equal(t, ErrTrackerClosed, tracker.Flush(context.Background()))
+}
+
+func TestTrackerQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer server.Close()
+
+	store := NewMemoryVisitorStore()
+	tracker := NewTracker(NewClient(server.URL, WithSiteID("1"), WithVisitorStore(store, 0)), TrackerOptions{
+		QueueSize:     1,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+	})
+	visitor := &Parameters{UserParameters: &UserParameters{UserID: StringPtr("user@example.com")}}
+	// the first event is picked up by the loop, which then blocks on the server, so the second fills the queue
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.Eventually(t, func() bool {
+		return tracker.Len() == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Nil(t, tracker.Track(&Parameters{}))
+	assert.Equal(t, ErrQueueFull, tracker.Track(&Parameters{}))
+
+	// events that are not queued don't count towards the visitor's visits
+	assert.Equal(t, ErrQueueFull, tracker.Track(visitor))
+	close(release)
+	assert.Nil(t, tracker.Close(context.Background()))
+	assert.Equal(t, ErrTrackerClosed, tracker.Track(visitor))
+	visit, err := store.Load("1:uid:user@example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, visit)
+}
@@ -0,0 +1,91 @@
+package matomo
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// httpOptions are the HTTP settings collected from the Options, applied once they have all run so they can be
+// given in any order
+type httpOptions struct {
+	client    *http.Client
+	transport http.RoundTripper
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	userAgent string
+}
+
+// WithHTTPClient makes the Client send requests with hc, such as one shared with the rest of your service or
+// instrumented for tracing. The Client works on a copy of hc, so the other options never change it.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpOptions.client = hc
+	}
+}
+
+// WithTransport makes the Client send requests through transport, for example to add a proxy, mTLS certificates
+// or instrumentation
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Client) {
+		c.httpOptions.transport = transport
+	}
+}
+
+// WithTimeout limits how long each attempt to send a request may take, including reading the response. It is
+// applied to every attempt separately, so use a context deadline to limit the time spent on retries.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.httpOptions.timeout = timeout
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to Matomo, for example to trust a private certificate
+// authority or present a client certificate. It only applies to an *http.Transport, so it is ignored when
+// WithTransport is given any other RoundTripper.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.httpOptions.tlsConfig = config
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests the Client sends. This is not the visitor's user
+// agent, which is sent in UserParameters.UserAgent.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.httpOptions.userAgent = userAgent
+	}
+}
+
+// build creates the HTTP client the Client uses for every request, so connections are kept alive and reused.
+// The http.Client and *http.Transport given in the options are copied before they are changed, so they can still
+// be shared with the rest of your service.
+func (options *httpOptions) build() *resty.Client {
+	var client *resty.Client
+	if options.client != nil {
+		hc := *options.client
+		client = resty.NewWithClient(&hc)
+	} else {
+		// without a cookie jar, so a cookie Matomo sets for one visitor is never sent with another's events
+		client = resty.NewWithClient(&http.Client{})
+	}
+	if options.transport != nil {
+		client.SetTransport(options.transport)
+	}
+	if options.tlsConfig != nil {
+		if transport, ok := client.GetClient().Transport.(*http.Transport); ok {
+			transport = transport.Clone()
+			transport.TLSClientConfig = options.tlsConfig
+			client.SetTransport(transport)
+		}
+	}
+	if options.timeout > 0 {
+		client.SetTimeout(options.timeout)
+	}
+	if options.userAgent != "" {
+		client.SetHeader("User-Agent", options.userAgent)
+	}
+	return client
+}
@@ -0,0 +1,137 @@
+package matomo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionReuse(t *testing.T) {
+	connections := int32(0)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("1"))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, client.Send(&Parameters{}))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connections))
+}
+
+type countingTransport struct {
+	requests int32
+	next     http.RoundTripper
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.requests, 1)
+	return c.next.RoundTrip(r)
+}
+
+func TestHTTPOptions(t *testing.T) {
+	userAgents := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.UserAgent()
+		if r.URL.Query().Get("action_name") == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// the options apply in any order
+	transport := &countingTransport{next: http.DefaultTransport}
+	client := NewClient(server.URL,
+		WithUserAgent("my-service/1.0"),
+		WithTimeout(50*time.Millisecond),
+		WithTransport(transport),
+		WithHTTPClient(&http.Client{}),
+		WithSiteID("1"),
+	)
+	assert.Nil(t, client.Send(&Parameters{}))
+	assert.Equal(t, "my-service/1.0", <-userAgents)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&transport.requests))
+
+	err := client.Send(&Parameters{RecommendedParameters: &RecommendedParameters{ActionName: StringPtr("slow")}})
+	assert.ErrorIs(t, err, ErrTimeout)
+}
+
+func TestTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// the test server's certificate is not trusted by default
+	assert.NotNil(t, NewClient(server.URL, WithSiteID("1")).Send(&Parameters{}))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	client := NewClient(server.URL, WithSiteID("1"), WithTLSConfig(&tls.Config{RootCAs: pool}))
+	assert.Nil(t, client.Send(&Parameters{}))
+}
+
+func TestHTTPOptionsDoNotChangeCallerClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	callerTLS := &tls.Config{}
+	transport := &http.Transport{TLSClientConfig: callerTLS}
+	hc := &http.Client{}
+	client := NewClient(server.URL,
+		WithSiteID("1"),
+		WithHTTPClient(hc),
+		WithTransport(transport),
+		WithTimeout(time.Second),
+		WithTLSConfig(&tls.Config{RootCAs: pool}),
+	)
+	assert.Nil(t, client.Send(&Parameters{}))
+	assert.Nil(t, hc.Transport)
+	assert.Zero(t, hc.Timeout)
+	assert.Same(t, callerTLS, transport.TLSClientConfig)
+	assert.Nil(t, callerTLS.RootCAs)
+
+	// a client without a transport must not get one, and the default transport is never changed
+	hc = &http.Client{}
+	client = NewClient(server.URL, WithSiteID("1"), WithHTTPClient(hc), WithTLSConfig(&tls.Config{RootCAs: pool}))
+	assert.Nil(t, client.Send(&Parameters{}))
+	assert.Nil(t, hc.Transport)
+	if config := http.DefaultTransport.(*http.Transport).TLSClientConfig; config != nil {
+		assert.Nil(t, config.RootCAs)
+	}
+}
+
+func TestNoCookiesBetweenSends(t *testing.T) {
+	cookies := make(chan int, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookies <- len(r.Cookies())
+		http.SetCookie(w, &http.Cookie{Name: "_pk_uid", Value: "0123456789abcdef"})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithSiteID("1"))
+	assert.Nil(t, client.Send(&Parameters{}))
+	assert.Nil(t, client.Send(&Parameters{}))
+	assert.Equal(t, 0, <-cookies)
+	assert.Equal(t, 0, <-cookies)
+}
@@ -0,0 +1,194 @@
+package matomo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxValueLengths are the longest values Matomo stores for a parameter, anything longer is truncated
+var maxValueLengths = map[string]int{
+	"action_name": 4096,
+	"url":         4096,
+	"urlref":      4096,
+	"link":        4096,
+	"download":    4096,
+	"search":      4096,
+	"e_c":         4096,
+	"e_a":         4096,
+	"e_n":         4096,
+	"c_n":         4096,
+	"c_p":         4096,
+	"c_t":         4096,
+	"c_i":         4096,
+	"uid":         200,
+	"_rcn":        255,
+	"_rck":        255,
+}
+
+// maxDimensionLength is the longest custom dimension value Matomo stores
+const maxDimensionLength = 255
+
+// ValidationProblem is a single problem found by Validate
+type ValidationProblem struct {
+	// Parameter is the name of the Matomo parameter with the problem, such as e_a or _id
+	Parameter string
+	// Message describes the problem
+	Message string
+}
+
+// ValidationError is returned by Validate, and by a strict Client, with every problem found in the parameters
+type ValidationError struct {
+	Problems []ValidationProblem
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		problems = append(problems, problem.Parameter+": "+problem.Message)
+	}
+	return "the parameters are not valid: " + strings.Join(problems, "; ")
+}
+
+// Is reports whether the problems include parameters that need a token_auth, so errors.Is(err, ErrTokenRequired)
+// behaves the same whether or not the Client is strict
+func (e *ValidationError) Is(target error) bool {
+	if target != ErrTokenRequired {
+		return false
+	}
+	for _, problem := range e.Problems {
+		if problem.Message == tokenRequiredMessage {
+			return true
+		}
+	}
+	return false
+}
+
+const tokenRequiredMessage = "requires a token_auth"
+
+// WithStrictValidation makes the Client validate every request before it is sent or queued, returning a
+// *ValidationError instead of sending parameters Matomo would ignore or truncate. Unlike Validate, the Client also
+// reports parameters that need a token_auth when it has none.
+func WithStrictValidation() Option {
+	return func(c *Client) {
+		c.strict = true
+	}
+}
+
+// Validate checks the parameters for mistakes that Matomo would otherwise silently ignore, such as an event
+// without both a category and an action, a visitor ID that isn't 16 hex characters, negative revenue or values
+// longer than Matomo stores. It returns a *ValidationError listing every problem, or nil. Validate doesn't know
+// whether a token_auth will be sent, so parameters that need one are only reported by a strict Client.
+func (params *Parameters) Validate() error {
+	_, err := params.validate()
+	return err
+}
+
+// validate returns the encoded parameters along with any problems found in them
+func (params *Parameters) validate() (map[string]string, *ValidationError) {
+	problems := []ValidationProblem{}
+	add := func(parameter, message string) {
+		problems = append(problems, ValidationProblem{Parameter: parameter, Message: message})
+	}
+
+	if params.RecommendedParameters != nil && params.RecommendedParameters.VisitorID != nil &&
+		!IsValidVisitorID(*params.RecommendedParameters.VisitorID) {
+		add("_id", "must be a 16 character hexadecimal string")
+	}
+	if event := params.EventTrackingParameters; event != nil {
+		hasCategory := event.Category != nil && *event.Category != ""
+		hasAction := event.Action != nil && *event.Action != ""
+		if hasCategory && !hasAction {
+			add("e_a", "an event with a category also requires an action")
+		} else if hasAction && !hasCategory {
+			add("e_c", "an event with an action also requires a category")
+		} else if !hasCategory && (event.Name != nil || event.Value != nil) {
+			add("e_c", "an event requires a category and an action")
+		}
+	}
+	if params.ActionParameters != nil && params.ActionParameters.Revenue != nil && *params.ActionParameters.Revenue < 0 {
+		add("revenue", "must not be negative")
+	}
+	if ecommerce := params.EcommerceParameters; ecommerce != nil {
+		for _, amount := range []struct {
+			parameter string
+			value     *float64
+		}{
+			{"revenue", ecommerce.Revenue},
+			{"ec_st", ecommerce.SubTotal},
+			{"ec_tx", ecommerce.Tax},
+			{"ec_sh", ecommerce.Shipping},
+			{"ec_dt", ecommerce.Discount},
+		} {
+			if amount.value != nil && *amount.value < 0 {
+				add(amount.parameter, "must not be negative")
+			}
+		}
+		for i, item := range ecommerce.Items {
+			if item.SKU == "" {
+				add("ec_items", fmt.Sprintf("item %d requires a sku", i))
+			}
+			if item.Price < 0 || item.Quantity < 0 {
+				add("ec_items", fmt.Sprintf("item %d must not have a negative price or quantity", i))
+			}
+		}
+	}
+
+	data, err := Encode(params)
+	if err != nil {
+		add("", err.Error())
+		return nil, &ValidationError{Problems: problems}
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		limit, ok := maxValueLengths[key]
+		if !ok && strings.HasPrefix(key, "dimension") {
+			limit, ok = maxDimensionLength, true
+		}
+		if ok && len([]rune(data[key])) > limit {
+			add(key, fmt.Sprintf("must not be longer than %d characters", limit))
+		}
+	}
+
+	if len(problems) > 0 {
+		return data, &ValidationError{Problems: problems}
+	}
+	return data, nil
+}
+
+// validateRequest validates the parameters for a strict Client, including whether a token_auth is needed
+func (c *Client) validateRequest(params *Parameters) error {
+	if params == nil {
+		return nil
+	}
+	data, validationErr := params.validate()
+	if data != nil && c.config.TokenAuth == "" && requiresToken(data) {
+		if validationErr == nil {
+			validationErr = &ValidationError{}
+		}
+		needed := []string{}
+		for _, key := range authenticatedKeys {
+			if _, ok := data[key]; ok {
+				needed = append(needed, key)
+			}
+		}
+		if at, ok := requestTime(data); ok && time.Since(at) > 24*time.Hour {
+			needed = append(needed, "cdt")
+		}
+		for _, key := range needed {
+			validationErr.Problems = append(validationErr.Problems, ValidationProblem{
+				Parameter: key,
+				Message:   tokenRequiredMessage,
+			})
+		}
+	}
+	if validationErr != nil {
+		return validationErr
+	}
+	return nil
+}
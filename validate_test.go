@@ -0,0 +1,76 @@
+package matomo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, (&Parameters{}).Validate())
+	assert.Nil(t, (&Parameters{
+		RecommendedParameters:   &RecommendedParameters{VisitorID: StringPtr("0123456789abcdef")},
+		EventTrackingParameters: testEventParams,
+		EcommerceParameters:     testEcommerceParams,
+	}).Validate())
+
+	err := (&Parameters{
+		RecommendedParameters:   &RecommendedParameters{VisitorID: StringPtr("user-42")},
+		UserParameters:          &UserParameters{UserID: StringPtr(strings.Repeat("u", 201))},
+		EventTrackingParameters: &EventTrackingParameters{Category: StringPtr("videos")},
+		ActionParameters:        &ActionParameters{Dimensions: map[int]string{1: strings.Repeat("d", 256)}},
+		EcommerceParameters: &EcommerceParameters{
+			Revenue: Float64Ptr(-1),
+			Items:   []EcommerceItem{{Name: "no sku", Price: 5, Quantity: 1}},
+		},
+	}).Validate()
+	validationErr := &ValidationError{}
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []ValidationProblem{
+		{Parameter: "_id", Message: "must be a 16 character hexadecimal string"},
+		{Parameter: "e_a", Message: "an event with a category also requires an action"},
+		{Parameter: "revenue", Message: "must not be negative"},
+		{Parameter: "ec_items", Message: "item 0 requires a sku"},
+		{Parameter: "dimension1", Message: "must not be longer than 255 characters"},
+		{Parameter: "uid", Message: "must not be longer than 200 characters"},
+	}, validationErr.Problems)
+	assert.Contains(t, err.Error(), "_id: must be a 16 character hexadecimal string; e_a:")
+	assert.False(t, errors.Is(err, ErrTokenRequired))
+
+	// Validate doesn't know about the token, so authenticated parameters are fine
+	authenticated := &Parameters{AuthenticatedParameters: &AuthenticatedParameters{VisitorIP: StringPtr("203.0.113.7")}}
+	assert.Nil(t, authenticated.Validate())
+}
+
+func TestStrictClient(t *testing.T) {
+	client := NewClient("https://matomo.example.com", WithSiteID("1"), WithStrictValidation())
+
+	_, err := client.buildRequest("1", &Parameters{EventTrackingParameters: &EventTrackingParameters{Action: StringPtr("play")}})
+	validationErr := &ValidationError{}
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "e_c", validationErr.Problems[0].Parameter)
+
+	// without a token, the parameters that need one are listed too
+	_, err = client.buildRequest("1", &Parameters{AuthenticatedParameters: &AuthenticatedParameters{
+		VisitorIP:   StringPtr("203.0.113.7"),
+		RequestTime: TimePtr(time.Now().Add(-48 * time.Hour)),
+	}})
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []ValidationProblem{
+		{Parameter: "cip", Message: "requires a token_auth"},
+		{Parameter: "cdt", Message: "requires a token_auth"},
+	}, validationErr.Problems)
+	assert.True(t, errors.Is(err, ErrTokenRequired))
+
+	_, err = client.buildRequest("1", &Parameters{EventTrackingParameters: testEventParams})
+	assert.Nil(t, err)
+
+	// a lenient client sends the same event, which Matomo will ignore
+	_, err = NewClient("https://matomo.example.com").buildRequest("1", &Parameters{
+		EventTrackingParameters: &EventTrackingParameters{Action: StringPtr("play")},
+	})
+	assert.Nil(t, err)
+}
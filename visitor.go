@@ -0,0 +1,123 @@
+package matomo
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// visitorIDLength is the number of hex characters in a visitor ID
+const visitorIDLength = 16
+
+// ErrNoVisitorCookie is returned when the request has no valid _pk_id cookie for the site
+var ErrNoVisitorCookie = errors.New("the request has no valid matomo visitor cookie for the site")
+
+// VisitorCookie is the visitor information the JavaScript tracker stores in its _pk_id cookie
+type VisitorCookie struct {
+	// VisitorID is the 16 character hex visitor ID
+	VisitorID string
+	// FirstVisit is when the cookie was created
+	FirstVisit time.Time
+	// VisitCount is the number of visits, if the cookie was set by an older tracker that stores it, or 0
+	VisitCount int64
+	// PreviousVisit is the time of the previous visit, if the cookie was set by an older tracker that stores it
+	PreviousVisit time.Time
+}
+
+// VisitorIDFromUserID derives a visitor ID from a user ID the same way Matomo does, by taking the first 16 hex
+// characters of its SHA-1 hash. Using it for the VisitorID means visits made before and after logging in are
+// attributed to the same visitor.
+func VisitorIDFromUserID(userID string) string {
+	sum := sha1.Sum([]byte(userID))
+	return hex.EncodeToString(sum[:])[:visitorIDLength]
+}
+
+// NewVisitorID generates a random visitor ID
+func NewVisitorID() string {
+	id := make([]byte, visitorIDLength/2)
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand does not fail on supported platforms, but fall back to the time rather than panicking
+		return VisitorIDFromUserID(strconv.FormatInt(time.Now().UnixNano(), 10))
+	}
+	return hex.EncodeToString(id)
+}
+
+// IsValidVisitorID returns true if the ID is a 16 character hex string, which is the only format Matomo accepts
+func IsValidVisitorID(id string) bool {
+	if len(id) != visitorIDLength {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// ParseVisitorCookie reads the visitor cookie the JavaScript tracker set for the site, so events tracked on the
+// server are attributed to the same visitor as the ones tracked in the browser. The cookie is named
+// _pk_id.<siteid>.<hash>, where the hash depends on the cookie domain and path, so the first valid cookie for the
+// site is used. It returns ErrNoVisitorCookie if there isn't one.
+func ParseVisitorCookie(r *http.Request, siteID string) (*VisitorCookie, error) {
+	prefix := "_pk_id." + siteID + "."
+	for _, cookie := range r.Cookies() {
+		if !strings.HasPrefix(cookie.Name, prefix) {
+			continue
+		}
+		if visitor, ok := parseVisitorCookieValue(cookie.Value); ok {
+			return visitor, nil
+		}
+	}
+	return nil, ErrNoVisitorCookie
+}
+
+// parseVisitorCookieValue parses visitorId.createTs, followed by visitCount.nowTs.lastVisitTs in older trackers
+func parseVisitorCookieValue(value string) (*VisitorCookie, bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) < 2 || !IsValidVisitorID(parts[0]) {
+		return nil, false
+	}
+	created, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	visitor := &VisitorCookie{
+		VisitorID:  strings.ToLower(parts[0]),
+		FirstVisit: time.Unix(created, 0),
+	}
+	if len(parts) >= 5 {
+		if count, err := strconv.ParseInt(parts[2], 10, 64); err == nil {
+			visitor.VisitCount = count
+		}
+		if previous, err := strconv.ParseInt(parts[4], 10, 64); err == nil && previous > 0 {
+			visitor.PreviousVisit = time.Unix(previous, 0)
+		}
+	}
+	return visitor, true
+}
+
+// Apply sets the visitor ID and any visit details from the cookie on params, without replacing values that are
+// already set
+func (visitor *VisitorCookie) Apply(params *Parameters) {
+	if params.RecommendedParameters == nil {
+		params.RecommendedParameters = &RecommendedParameters{}
+	}
+	if params.RecommendedParameters.VisitorID == nil {
+		params.RecommendedParameters.VisitorID = StringPtr(visitor.VisitorID)
+	}
+	if params.UserParameters == nil {
+		params.UserParameters = &UserParameters{}
+	}
+	user := params.UserParameters
+	if user.IDTS == nil && !visitor.FirstVisit.IsZero() {
+		user.IDTS = Int64Ptr(visitor.FirstVisit.Unix())
+	}
+	if user.IDVC == nil && visitor.VisitCount > 0 {
+		user.IDVC = Int64Ptr(visitor.VisitCount)
+	}
+	if user.ViewTS == nil && !visitor.PreviousVisit.IsZero() {
+		user.ViewTS = Int64Ptr(visitor.PreviousVisit.Unix())
+	}
+}
@@ -0,0 +1,59 @@
+package matomo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVisitorIDs(t *testing.T) {
+	// the first 16 characters of the SHA-1 of "user@example.com"
+	assert.Equal(t, "63a710569261a24b", VisitorIDFromUserID("user@example.com"))
+	assert.True(t, IsValidVisitorID(VisitorIDFromUserID("user@example.com")))
+
+	first := NewVisitorID()
+	second := NewVisitorID()
+	assert.True(t, IsValidVisitorID(first))
+	assert.NotEqual(t, first, second)
+
+	assert.True(t, IsValidVisitorID("0123456789ABCDEF"))
+	assert.False(t, IsValidVisitorID(""))
+	assert.False(t, IsValidVisitorID("0123456789abcde"))
+	assert.False(t, IsValidVisitorID("0123456789abcdeg"))
+}
+
+func TestParseVisitorCookie(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "_pk_id.2.1fff", Value: "aaaaaaaaaaaaaaaa.1600000000."})
+	r.AddCookie(&http.Cookie{Name: "_pk_id.1.1fff", Value: "not-a-visitor.1600000000."})
+	r.AddCookie(&http.Cookie{Name: "_pk_id.1.2aaa", Value: "0123456789ABCDEF.1600000000."})
+
+	visitor, err := ParseVisitorCookie(r, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789abcdef", visitor.VisitorID)
+	assert.Equal(t, time.Unix(1600000000, 0), visitor.FirstVisit)
+	assert.Equal(t, int64(0), visitor.VisitCount)
+	assert.True(t, visitor.PreviousVisit.IsZero())
+
+	_, err = ParseVisitorCookie(r, "3")
+	assert.Equal(t, ErrNoVisitorCookie, err)
+
+	// older trackers also store the visit count and the previous visit
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "_pk_id.1.1fff", Value: "0123456789abcdef.1600000000.4.1600300000.1600200000."})
+	visitor, err = ParseVisitorCookie(r, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), visitor.VisitCount)
+	assert.Equal(t, time.Unix(1600200000, 0), visitor.PreviousVisit)
+
+	params := &Parameters{UserParameters: &UserParameters{IDVC: Int64Ptr(9)}}
+	visitor.Apply(params)
+	assert.Equal(t, "0123456789abcdef", *params.RecommendedParameters.VisitorID)
+	assert.Equal(t, int64(1600000000), *params.UserParameters.IDTS)
+	assert.Equal(t, int64(1600200000), *params.UserParameters.ViewTS)
+	// values that were already set are kept
+	assert.Equal(t, int64(9), *params.UserParameters.IDVC)
+}
@@ -0,0 +1,197 @@
+package matomo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Visit is the state a VisitorStore keeps for each visitor, used to fill in the visit count and timestamps Matomo
+// otherwise expects the application to track
+type Visit struct {
+	// Count is the number of visits, including the current one
+	Count int64 `json:"count"`
+	// FirstVisit is the start of the visitor's first visit
+	FirstVisit time.Time `json:"first_visit"`
+	// PreviousVisit is the start of the visit before the current one, or zero if this is the first
+	PreviousVisit time.Time `json:"previous_visit"`
+	// CurrentVisit is the start of the current visit
+	CurrentVisit time.Time `json:"current_visit"`
+	// LastAction is the time of the visitor's most recent request
+	LastAction time.Time `json:"last_action"`
+}
+
+// VisitorStore persists a Visit for each visitor. Load returns nil, with no error, for a visitor it has not seen.
+// The Client serializes its calls, so an implementation only needs to be safe for use by several Clients if it is
+// shared between them.
+type VisitorStore interface {
+	Load(key string) (*Visit, error)
+	Save(key string, visit *Visit) error
+}
+
+// WithVisitorStore makes the Client keep track of each visitor's visits in store, filling in _idvc, _viewts and
+// _idts when they are not set and setting new_visit when a visitor returns after visitTimeout of inactivity.
+// Visitors are identified by their uid, or if it isn't set, their _id, so requests with neither are left alone.
+// A visitTimeout of zero uses Matomo's default of 30 minutes. If the store fails, the request is sent without
+// the visit details.
+func WithVisitorStore(store VisitorStore, visitTimeout time.Duration) Option {
+	return func(c *Client) {
+		if visitTimeout <= 0 {
+			visitTimeout = 30 * time.Minute
+		}
+		c.visitors = store
+		c.visitTimeout = visitTimeout
+	}
+}
+
+// trackVisit records the request in the visitor store and adds the visit details to data
+func (c *Client) trackVisit(siteID string, data map[string]string) {
+	if c.visitors == nil {
+		return
+	}
+	key := visitorKey(siteID, data)
+	if key == "" {
+		return
+	}
+	at, ok := requestTime(data)
+	if !ok {
+		at = time.Now()
+	}
+
+	c.visitorsMu.Lock()
+	defer c.visitorsMu.Unlock()
+	visit, err := c.visitors.Load(key)
+	if err != nil {
+		c.logger.Warn("the visitor store failed, so the request is sent without visit details", "error", err)
+		return
+	}
+	switch {
+	case visit == nil:
+		visit = &Visit{Count: 1, FirstVisit: at, CurrentVisit: at, LastAction: at}
+	case at.Sub(visit.LastAction) > c.visitTimeout:
+		visit.Count++
+		visit.PreviousVisit = visit.CurrentVisit
+		visit.CurrentVisit = at
+		visit.LastAction = at
+		if _, ok := data["new_visit"]; !ok {
+			data["new_visit"] = "1"
+		}
+	case at.After(visit.LastAction):
+		visit.LastAction = at
+	}
+	if err := c.visitors.Save(key, visit); err != nil {
+		c.logger.Warn("the visitor store failed, so the request is sent without visit details", "error", err)
+		return
+	}
+
+	if _, ok := data["_idvc"]; !ok {
+		data["_idvc"] = fmt.Sprintf("%d", visit.Count)
+	}
+	if _, ok := data["_idts"]; !ok {
+		data["_idts"] = fmt.Sprintf("%d", visit.FirstVisit.Unix())
+	}
+	if _, ok := data["_viewts"]; !ok && !visit.PreviousVisit.IsZero() {
+		data["_viewts"] = fmt.Sprintf("%d", visit.PreviousVisit.Unix())
+	}
+}
+
+// visitorKey identifies the visitor of an encoded request within a site, or returns an empty string if the
+// request doesn't identify one
+func visitorKey(siteID string, data map[string]string) string {
+	if uid, ok := data["uid"]; ok && uid != "" {
+		return siteID + ":uid:" + uid
+	}
+	if id, ok := data["_id"]; ok && id != "" {
+		return siteID + ":id:" + id
+	}
+	return ""
+}
+
+// MemoryVisitorStore keeps visits in memory, so they are lost when the process exits and are not shared between
+// instances of a service. Entries are never removed, so it is best suited to short lived processes and tests.
+type MemoryVisitorStore struct {
+	mu     sync.Mutex
+	visits map[string]Visit
+}
+
+// NewMemoryVisitorStore creates an empty MemoryVisitorStore
+func NewMemoryVisitorStore() *MemoryVisitorStore {
+	return &MemoryVisitorStore{
+		visits: map[string]Visit{},
+	}
+}
+
+// Load returns a copy of the visitor's visit, or nil if it is unknown
+func (s *MemoryVisitorStore) Load(key string) (*Visit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	visit, ok := s.visits[key]
+	if !ok {
+		return nil, nil
+	}
+	return &visit, nil
+}
+
+// Save stores a copy of the visit
+func (s *MemoryVisitorStore) Save(key string, visit *Visit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.visits[key] = *visit
+	return nil
+}
+
+// FileVisitorStore keeps each visitor's visit in its own JSON file in a directory, so visits survive restarts.
+// Files are named by a hash of the visitor key, so user IDs are not exposed in file names.
+type FileVisitorStore struct {
+	dir string
+}
+
+// NewFileVisitorStore creates a FileVisitorStore that stores visits in dir, creating the directory if needed
+func NewFileVisitorStore(dir string) (*FileVisitorStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileVisitorStore{
+		dir: dir,
+	}, nil
+}
+
+// Load reads the visitor's visit, or returns nil if it has no file
+func (s *FileVisitorStore) Load(key string) (*Visit, error) {
+	contents, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	visit := &Visit{}
+	if err := json.Unmarshal(contents, visit); err != nil {
+		return nil, err
+	}
+	return visit, nil
+}
+
+// Save writes the visit to a temporary file and renames it into place, so a crash never leaves a partial file
+func (s *FileVisitorStore) Save(key string, visit *Visit) error {
+	contents, err := json.Marshal(visit)
+	if err != nil {
+		return err
+	}
+	path := s.path(key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, contents, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileVisitorStore) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
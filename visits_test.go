@@ -0,0 +1,98 @@
+package matomo
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVisitorStoreBookkeeping(t *testing.T) {
+	for name, store := range map[string]VisitorStore{
+		"memory": NewMemoryVisitorStore(),
+		"file":   mustFileVisitorStore(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := NewClient("https://matomo.example.com", WithSiteID("1"), WithVisitorStore(store, 0))
+			start := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+			build := func(at time.Time) map[string]string {
+				data, err := client.buildRequest("1", &Parameters{
+					UserParameters:          &UserParameters{UserID: StringPtr("user@example.com")},
+					AuthenticatedParameters: &AuthenticatedParameters{RequestTime: TimePtr(at)},
+				})
+				assert.Nil(t, err)
+				return data
+			}
+
+			// the first request starts the first visit
+			data := build(start)
+			assert.Equal(t, "1", data["_idvc"])
+			assert.Equal(t, timestamp(start), data["_idts"])
+			assert.Empty(t, data["_viewts"])
+			assert.Empty(t, data["new_visit"])
+
+			// a request within the timeout is part of the same visit
+			data = build(start.Add(20 * time.Minute))
+			assert.Equal(t, "1", data["_idvc"])
+			assert.Empty(t, data["new_visit"])
+
+			// a request after the timeout starts a new visit
+			data = build(start.Add(time.Hour))
+			assert.Equal(t, "2", data["_idvc"])
+			assert.Equal(t, timestamp(start), data["_idts"])
+			assert.Equal(t, timestamp(start), data["_viewts"])
+			assert.Equal(t, "1", data["new_visit"])
+
+			// other visitors and sites are tracked separately
+			data, err := client.buildRequest("2", &Parameters{UserParameters: &UserParameters{UserID: StringPtr("user@example.com")}})
+			assert.Nil(t, err)
+			assert.Equal(t, "1", data["_idvc"])
+			data, err = client.buildRequest("1", &Parameters{RecommendedParameters: &RecommendedParameters{VisitorID: StringPtr("0123456789abcdef")}})
+			assert.Nil(t, err)
+			assert.Equal(t, "1", data["_idvc"])
+
+			// values set by the caller are kept, and anonymous requests are left alone
+			data, err = client.buildRequest("1", &Parameters{UserParameters: &UserParameters{
+				UserID: StringPtr("user@example.com"),
+				IDVC:   Int64Ptr(7),
+			}})
+			assert.Nil(t, err)
+			assert.Equal(t, "7", data["_idvc"])
+			data, err = client.buildRequest("1", &Parameters{})
+			assert.Nil(t, err)
+			assert.Empty(t, data["_idvc"])
+		})
+	}
+}
+
+func TestFileVisitorStorePersists(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileVisitorStore(dir)
+	assert.Nil(t, err)
+	visit, err := store.Load("1:uid:someone")
+	assert.Nil(t, err)
+	assert.Nil(t, visit)
+
+	now := time.Unix(1600000000, 0)
+	assert.Nil(t, store.Save("1:uid:someone", &Visit{Count: 3, FirstVisit: now, CurrentVisit: now, LastAction: now}))
+
+	reopened, err := NewFileVisitorStore(dir)
+	assert.Nil(t, err)
+	visit, err = reopened.Load("1:uid:someone")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), visit.Count)
+	assert.True(t, now.Equal(visit.FirstVisit))
+}
+
+func mustFileVisitorStore(t *testing.T) *FileVisitorStore {
+	store, err := NewFileVisitorStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func timestamp(at time.Time) string {
+	return strconv.FormatInt(at.Unix(), 10)
+}